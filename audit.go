@@ -0,0 +1,85 @@
+package log
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// auditName is the name of the audit logger.
+const auditName = "audit"
+
+// Audit returns the audit logger of the std logger.
+func Audit() Logger { return std().Audit() }
+
+// Audit returns a logger writing to AuditOutputPath, for the events which must
+// never be dropped. Its entries are encoded as JSON and bypass the level gate,
+// Pause, the sampler and the core wrappers, and the file is synced after every
+// write, so an entry is on disk once the logging call returns. The names and
+// fields added to the logger, with WithName or WithValues for instance, are
+// added to the audit logger as well. Without AuditOutputPath, it returns the
+// logger itself.
+func (l *zapLogger) Audit() Logger {
+	if l.state == nil || l.state.audit == nil {
+		return l
+	}
+
+	audit := l.state.audit
+	for _, name := range l.names {
+		audit = audit.Named(name)
+	}
+	if len(l.context) > 0 {
+		audit = audit.With(l.context...)
+	}
+
+	return l.derive(audit)
+}
+
+// buildAudit builds the audit logger writing to AuditOutputPath. It returns a
+// nil logger without AuditOutputPath. Its Panic and Fatal level entries behave
+// as those of the main logger, flushed by syncFn.
+func (o *Options) buildAudit(errSink zapcore.WriteSyncer, syncFn func() error, fields []zap.Field, extra ...zap.Option) (*zap.Logger, func(), error) {
+	if o.AuditOutputPath == "" {
+		return nil, func() {}, nil
+	}
+	sink, closeOut, err := zap.Open(o.AuditOutputPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg := o.encoderConfig()
+	cfg.EncodeLevel = zapcore.CapitalLevelEncoder
+	core := &syncCore{Core: zapcore.NewCore(zapcore.NewJSONEncoder(cfg), sink, zapcore.DebugLevel), out: sink}
+
+	opts := []zap.Option{zap.ErrorOutput(errSink), zap.WithCaller(!o.DisableCaller), zap.WithClock(o.clock())}
+	opts = append(opts, o.terminalHooks(syncFn)...)
+	if len(fields) > 0 {
+		opts = append(opts, zap.Fields(fields...))
+	}
+
+	return zap.New(core, append(opts, extra...)...).Named(auditName), closeOut, nil
+}
+
+// syncCore syncs its output after every entry it writes.
+type syncCore struct {
+	zapcore.Core
+	out zapcore.WriteSyncer
+}
+
+func (c *syncCore) With(fields []zapcore.Field) zapcore.Core {
+	return &syncCore{Core: c.Core.With(fields), out: c.out}
+}
+
+func (c *syncCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *syncCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if err := c.Core.Write(ent, fields); err != nil {
+		return err
+	}
+
+	return c.out.Sync()
+}
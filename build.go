@@ -0,0 +1,724 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// encoderConfig returns the encoder configuration shared by all the encoders
+// built from the options.
+func (o *Options) encoderConfig() zapcore.EncoderConfig {
+	return o.formatEncoderConfig(o.Format)
+}
+
+// formatEncoderConfig returns the encoder configuration of the given format.
+func (o *Options) formatEncoderConfig(format string) zapcore.EncoderConfig {
+	encodeLevel := zapcore.CapitalLevelEncoder
+	// when output to local path, with color is forbidden
+	if format == consoleFormat {
+		encodeLevel = zapcore.CapitalColorLevelEncoder
+		if len(o.LevelColors) > 0 {
+			encodeLevel = coloredLevelEncoder(o.LevelColors, o.ParseLevel)
+		}
+	}
+
+	return zapcore.EncoderConfig{
+		MessageKey:     "message",
+		LevelKey:       "level",
+		TimeKey:        "timestamp",
+		NameKey:        "logger",
+		CallerKey:      "caller",
+		StacktraceKey:  "stacktrace",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    encodeLevel,
+		EncodeTime:     timeEncoderFor(o.TimeFormat),
+		EncodeDuration: milliSecondsDurationEncoder,
+		EncodeCaller:   callerEncoder(o.CallerEncoder),
+		EncodeName:     zapcore.FullNameEncoder,
+	}
+}
+
+// newEncoder creates the encoder selected by Format.
+func (o *Options) newEncoder() zapcore.Encoder {
+	return o.newFormatEncoder(o.Format)
+}
+
+// newFormatEncoder creates the encoder of the given format.
+func (o *Options) newFormatEncoder(format string) zapcore.Encoder {
+	cfg := o.formatEncoderConfig(format)
+	if factory, ok := lookupEncoder(format); ok {
+		return factory(cfg)
+	}
+	if format == ecsFormat || format == jsonFormat {
+		enc := zapcore.NewJSONEncoder(cfg)
+		if format == ecsFormat {
+			enc = newECSEncoder(cfg)
+		}
+		if o.Int64AsString {
+			enc = &int64StringEncoder{Encoder: enc}
+		}
+		if format == jsonFormat && o.JSONSeq {
+			enc = &jsonSeqEncoder{Encoder: enc}
+		}
+
+		return enc
+	}
+	enc := zapcore.NewConsoleEncoder(cfg)
+	if o.ConsoleMultiline {
+		enc = newMultilineEncoder(cfg)
+	}
+	if o.ConsoleWrap > 0 && !o.stdStreamsPiped() {
+		enc = &wrapEncoder{Encoder: enc, width: o.ConsoleWrap}
+	}
+	if o.EntrySeparator != "" {
+		level := zapcore.DebugLevel
+		if o.EntrySeparatorLevel != "" {
+			level, _ = o.ParseLevel(o.EntrySeparatorLevel)
+		}
+		enc = &separatorEncoder{Encoder: enc, separator: o.EntrySeparator, level: level}
+	}
+
+	return enc
+}
+
+// stdStreamsPiped reports whether stdout or stderr is one of the outputs and
+// is not a terminal, being redirected to a file or piped to another program.
+func (o *Options) stdStreamsPiped() bool {
+	streams := map[string]*os.File{"stdout": os.Stdout, "stderr": os.Stderr}
+	for _, path := range o.OutputPaths {
+		cfg, err := ParseOutputPath(path)
+		if f, ok := streams[cfg.Path]; err == nil && ok && !isTerminal(f) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isTerminal reports whether f is a character device, such as a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// zapLevel returns the configured level, falling back to info.
+func (o *Options) zapLevel() zapcore.Level {
+	zapLevel, err := o.ParseLevel(o.Level)
+	if err != nil {
+		return zapcore.InfoLevel
+	}
+
+	return zapLevel
+}
+
+// build assembles a zap logger from the options. Unlike zap.Config.Build,
+// the core is put together by hand so that our own core wrappers sit between
+// the sampler and the encoding core:
+//
+//	level gate -> sampler -> wrappers -> ioCore
+//
+// This way wrappers only ever see entries which survived sampling. The level
+// is only checked by the gate, the ioCores accept every level. With
+// NoSampleAbove, the entries at that level or above bypass the sampler.
+//
+// The sampler involves no randomness: for each level and message, it keeps
+// the first 100 entries of every second then every 100th, the second being
+// measured from the entry times. Entries logged with a fixed Clock are thus
+// always kept or dropped the same way. With SampleAnnotation, the entries
+// kept past the first 100 are annotated with the rate they were sampled at.
+func (o *Options) build(extra ...zap.Option) (*zap.Logger, *loggerState, error) {
+	if err := o.registerNetworkSinks(); err != nil {
+		return nil, nil, err
+	}
+	st := &stats{}
+	core, closeOut, err := o.buildIOCore(zapcore.DebugLevel, st)
+	if err != nil {
+		return nil, nil, err
+	}
+	errSink, _, err := zap.Open(o.ErrorOutputPaths...)
+	if err != nil {
+		closeOut()
+
+		return nil, nil, err
+	}
+	var ring *ringBuffer
+	if o.RingBufferSize > 0 {
+		ring = newRingBuffer(o.RingBufferSize)
+		enc := o.newEncoder()
+		if o.MaxEntryBytes > 0 {
+			enc = newMaxEntryEncoder(enc, o.MaxEntryBytes)
+		}
+		core = zapcore.NewTee(core, zapcore.NewCore(enc, ring, zapcore.DebugLevel))
+	}
+
+	start := o.clock().Now()
+	hooks := []func(zapcore.Entry, zapcore.SamplingDecision){st.samplerHook}
+	var reporter *samplingReporter
+	if o.SamplingReportInterval > 0 {
+		reporter = newSamplingReporter()
+		hooks = append(hooks, reporter.hook)
+	}
+
+	core = &statsCore{Core: core, stats: st}
+	core = o.wrapCore(core, st, start, errSink)
+	sampled := zapcore.NewSamplerWithOptions(core, time.Second, samplerFirst, samplerThereafter, zapcore.SamplerHook(samplerHooks(hooks...)))
+	if o.SampleAnnotation {
+		sampled = newSampleAnnotationCore(sampled)
+	}
+	if o.NoSampleAbove != "" {
+		above, _ := o.ParseLevel(o.NoSampleAbove)
+		sampled = &levelRouterCore{low: sampled, high: core, level: above}
+	}
+	core = sampled
+	paused := &atomic.Bool{}
+	level := zap.NewAtomicLevelAt(o.zapLevel())
+	enab := o.levelEnabler(level)
+	core = &gateCore{Core: core, paused: paused, enab: enab}
+
+	// Computed once, so that the FieldFuncs are only called once per build.
+	fields := o.initialFields()
+	var logger *zap.Logger
+	syncFn := func() error { return logger.Sync() }
+	logger = zap.New(core, append(o.zapOptions(errSink, syncFn, fields), extra...)...)
+	if _, dups := dedupePaths(o.OutputPaths); len(dups) > 0 {
+		logger.Warn("duplicate output paths ignored", zap.Strings("paths", dups))
+	}
+
+	audit, closeAudit, err := o.buildAudit(errSink, syncFn, fields, extra...)
+	if err != nil {
+		closeOut()
+
+		return nil, nil, err
+	}
+
+	stop := func() {}
+	if reporter != nil {
+		stop = reporter.start(logger.WithOptions(zap.WithCaller(false)), o.clock(), o.SamplingReportInterval)
+	}
+
+	return logger, &loggerState{
+		stats:  st,
+		level:  level,
+		paused: paused,
+		ring:   ring,
+		audit:  audit,
+		start:  start,
+		closeFn: func() {
+			stop()
+			closeOut()
+			closeAudit()
+		},
+	}, nil
+}
+
+// loggerState is the state built along with a logger and shared by all the
+// loggers derived from it.
+type loggerState struct {
+	stats *stats
+	// start is the time the logger was built at.
+	start time.Time
+	// level is the level checked by the gate, unless LevelEnabler is set.
+	level zap.AtomicLevel
+	// paused makes the level gate drop every entry.
+	paused *atomic.Bool
+	// ring keeps the most recent entries, nil without RingBufferSize.
+	ring *ringBuffer
+	// audit is the logger returned by Audit, nil without AuditOutputPath.
+	audit *zap.Logger
+
+	closeOnce sync.Once
+	// closeFn stops the background goroutines and buffers and closes the files
+	// opened for the output paths, it must only be called once the logger is
+	// synced.
+	closeFn func()
+}
+
+// errNoOutput is returned when building a logger without any output.
+var errNoOutput = errors.New("no valid output paths configured")
+
+// buildIOCore opens the output paths and creates the cores encoding entries
+// into them. With SplitStdStreams the stdout sink is split into two level
+// bounded cores, so that warnings and errors go to stderr instead. With
+// LogSizeAccounting, the encoded bytes are counted in st.
+func (o *Options) buildIOCore(level zapcore.LevelEnabler, st *stats) (zapcore.Core, func(), error) {
+	enc := o.wrapEncoder(o.newEncoder(), st)
+	newCore := func(ws zapcore.WriteSyncer) zapcore.Core {
+		return o.newIOCore(enc, ws, level, st)
+	}
+	paths, _ := dedupePaths(o.OutputPaths)
+	paths, leveled := splitOutputPaths(paths)
+
+	var (
+		cores   []zapcore.Core
+		closers []func()
+	)
+	closeAll := func() {
+		for _, closeFn := range closers {
+			closeFn()
+		}
+	}
+
+	if o.SplitStdStreams && containsString(paths, "stdout") {
+		paths = removeString(paths, "stdout")
+
+		stdout, closeStdout, err := o.openSink("stdout")
+		if err != nil {
+			return nil, nil, err
+		}
+		closers = append(closers, closeStdout)
+		stderr, closeStderr, err := o.openSink("stderr")
+		if err != nil {
+			closeAll()
+
+			return nil, nil, err
+		}
+		closers = append(closers, closeStderr)
+		cores = append(cores,
+			newLevelFilterCore(newCore(stdout), zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+				return l < zapcore.WarnLevel
+			})),
+			newLevelFilterCore(newCore(stderr), zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+				return l >= zapcore.WarnLevel
+			})),
+		)
+	}
+
+	if o.Writer != nil {
+		cores = append(cores, newCore(zapcore.AddSync(o.Writer)))
+	}
+
+	for _, sinkOpts := range append(leveled, o.Sinks...) {
+		sink, closeSink, err := o.openSink(sinkOpts.Path)
+		if err != nil {
+			closeAll()
+
+			return nil, nil, err
+		}
+		closers = append(closers, closeSink)
+		cores = append(cores, newLevelFilterCore(newCore(sink), sinkOpts.zapLevel(o)))
+	}
+
+	if len(paths) == 0 && len(cores) == 0 {
+		if !o.AllowNoOutput {
+			return nil, nil, errNoOutput
+		}
+
+		return zapcore.NewNopCore(), closeAll, nil
+	}
+
+	if len(paths) > 0 {
+		sink, closeSink, err := o.openSink(paths...)
+		if err != nil {
+			closeAll()
+
+			return nil, nil, err
+		}
+		closers = append(closers, closeSink)
+		cores = append(cores, newCore(sink))
+	}
+
+	return zapcore.NewTee(cores...), closeAll, nil
+}
+
+// wrapEncoder applies the encoder wrappers enabled by the options: with
+// MaxEntryBytes the size of the entries is bounded, with LogSizeAccounting the
+// encoded bytes are counted in st.
+func (o *Options) wrapEncoder(enc zapcore.Encoder, st *stats) zapcore.Encoder {
+	if o.MaxEntryBytes > 0 {
+		enc = newMaxEntryEncoder(enc, o.MaxEntryBytes)
+	}
+	if o.LogSizeAccounting {
+		enc = &sizeEncoder{Encoder: enc, stats: st}
+	}
+
+	return enc
+}
+
+// newIOCore creates a core encoding the entries into ws with a clone of enc.
+// With LevelFormats, the entries at the listed levels are encoded in their own
+// format instead.
+func (o *Options) newIOCore(enc zapcore.Encoder, ws zapcore.WriteSyncer, level zapcore.LevelEnabler, st *stats) zapcore.Core {
+	if len(o.LevelFormats) == 0 {
+		return zapcore.NewCore(enc.Clone(), ws, level)
+	}
+
+	formats := make(map[zapcore.Level]string, len(o.LevelFormats))
+	for name, format := range o.LevelFormats {
+		// Invalid levels are reported by Validate.
+		if lvl, err := o.ParseLevel(name); err == nil {
+			formats[lvl] = format
+		}
+	}
+	cores := []zapcore.Core{
+		newLevelFilterCore(zapcore.NewCore(enc.Clone(), ws, level), zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+			_, ok := formats[l]
+
+			return !ok
+		})),
+	}
+	for lvl, format := range formats {
+		cores = append(cores, newLevelFilterCore(
+			zapcore.NewCore(o.wrapEncoder(o.newFormatEncoder(format), st), ws, level),
+			zap.LevelEnablerFunc(func(l zapcore.Level) bool { return l == lvl }),
+		))
+	}
+
+	return zapcore.NewTee(cores...)
+}
+
+// openSink opens the given paths as a single sink. With BufferSize set, the
+// sink is buffered; the buffer is part of the core, so all the loggers derived
+// from the built logger share it instead of allocating their own. With
+// SinkQueueSize, every network path gets its own queue, so that a slow
+// connection does not hold up the writes to the other paths. The paths can be
+// URLs with parameters, see ParseOutputPath.
+func (o *Options) openSink(paths ...string) (zapcore.WriteSyncer, func(), error) {
+	sinks := make([]zapcore.WriteSyncer, 0, len(paths))
+	closers := make([]func(), 0, len(paths))
+	closeSinks := func() {
+		for _, closeFn := range closers {
+			closeFn()
+		}
+	}
+	var fallback zapcore.WriteSyncer
+	if o.FallbackOutputPath != "" && len(paths) > 0 {
+		sink, closeFn, err := zap.Open(o.FallbackOutputPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		fallback = sink
+		closers = append(closers, closeFn)
+	}
+	for _, path := range paths {
+		// Invalid paths are reported by Validate.
+		cfg, _ := ParseOutputPath(path)
+		if cfg.Rotation != (RotationConfig{}) {
+			closeSinks()
+
+			return nil, nil, fmt.Errorf("output path %q: %w", path, errRotationUnsupported)
+		}
+		sink, closeFn, err := zap.Open(cfg.Path)
+		if err != nil {
+			closeSinks()
+
+			return nil, nil, err
+		}
+		sink = o.wrapSink(cfg, sink)
+		if fallback != nil && path != o.FallbackOutputPath {
+			sink = &fallbackWriteSyncer{WriteSyncer: sink, path: path, fallback: fallback}
+		}
+		queueSize := o.SinkQueueSize
+		if cfg.QueueSize > 0 {
+			queueSize = cfg.QueueSize
+		}
+		if queueSize > 0 && isNetworkSink(cfg.Path) {
+			queued := newQueueWriteSyncer(sink, queueSize)
+			sink = queued
+			closeSink := closeFn
+			closeFn = func() {
+				queued.close()
+				closeSink()
+			}
+		}
+		sinks = append(sinks, sink)
+		closers = append(closers, closeFn)
+	}
+
+	sink := zapcore.NewMultiWriteSyncer(sinks...)
+	if o.BufferSize <= 0 {
+		return sink, closeSinks, nil
+	}
+
+	buffered := &zapcore.BufferedWriteSyncer{WS: sink, Size: o.BufferSize}
+
+	return buffered, func() {
+		_ = buffered.Stop()
+		closeSinks()
+	}, nil
+}
+
+// dedupePaths returns the output paths without the ones designating an
+// already listed destination, and the removed duplicates. File paths are
+// compared once cleaned, so "logs/app.log" and "./logs/app.log" are the same,
+// and URLs without parameters as the path they designate.
+func dedupePaths(paths []string) ([]string, []string) {
+	seen := make(map[string]struct{}, len(paths))
+	var unique, dups []string
+	for _, path := range paths {
+		key := path
+		if cfg, err := ParseOutputPath(path); err == nil && cfg == (OutputConfig{Path: cfg.Path}) {
+			key = cfg.Path
+		}
+		if !isStdStream(key) && !strings.Contains(key, "://") {
+			key = filepath.Clean(key)
+		}
+		if _, ok := seen[key]; ok {
+			dups = append(dups, path)
+
+			continue
+		}
+		seen[key] = struct{}{}
+		unique = append(unique, path)
+	}
+
+	return unique, dups
+}
+
+// splitOutputPaths separates the output paths with a level parameter, which
+// need their own core, from the other ones. The URLs without any parameter
+// are replaced by the plain path they designate, such as stdout for
+// stdout://.
+func splitOutputPaths(paths []string) ([]string, []SinkOptions) {
+	plain := make([]string, 0, len(paths))
+	var leveled []SinkOptions
+	for _, path := range paths {
+		cfg, err := ParseOutputPath(path)
+		switch {
+		case err != nil:
+			plain = append(plain, path)
+		case cfg.Level != "":
+			leveled = append(leveled, SinkOptions{Path: path, Level: cfg.Level})
+		case cfg == OutputConfig{Path: cfg.Path}:
+			plain = append(plain, cfg.Path)
+		default:
+			plain = append(plain, path)
+		}
+	}
+
+	return plain, leveled
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func removeString(ss []string, s string) []string {
+	out := make([]string, 0, len(ss))
+	for _, v := range ss {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+
+	return out
+}
+
+// levelEnabler returns the enabler deciding which levels are logged: the
+// LevelEnabler function when there is one, the level otherwise.
+func (o *Options) levelEnabler(level zap.AtomicLevel) zapcore.LevelEnabler {
+	if o.LevelEnabler != nil {
+		return zap.LevelEnablerFunc(o.LevelEnabler)
+	}
+
+	return level
+}
+
+// wrapCore applies the optional core wrappers enabled by the options. start
+// is the time the logger is built at, errSink the error output.
+func (o *Options) wrapCore(core zapcore.Core, st *stats, start time.Time, errSink zapcore.WriteSyncer) zapcore.Core {
+	// Numbered first, so that the entries dropped by the other wrappers leave
+	// no gap in the sequence.
+	if o.SequenceNumbers {
+		core = &sequenceCore{Core: core, seq: &atomic.Uint64{}}
+	}
+	if o.EncodeErrorRecovery {
+		core = &fieldRewriteCore{Core: core, rewrite: recoverEncodeErrors(errSink)}
+	}
+	if o.NumericLevelKey != "" {
+		core = &numericLevelCore{Core: core, key: o.NumericLevelKey}
+	}
+	if o.UptimeField {
+		core = &uptimeCore{Core: core, start: start}
+	}
+	if o.StructuredStacktrace && o.Format == jsonFormat {
+		core = &structuredStacktraceCore{Core: core}
+	}
+	if o.StacktraceDepth > 0 {
+		core = &stacktraceDepthCore{Core: core, depth: o.StacktraceDepth}
+	}
+	if o.MaskLongStrings > 0 {
+		core = &fieldRewriteCore{Core: core, rewrite: maskLongStrings(o.MaskLongStrings)}
+	}
+	if o.MaxFieldLength > 0 {
+		core = &fieldRewriteCore{Core: core, rewrite: truncateLongStrings(o.MaxFieldLength)}
+	}
+	if o.PIIRedaction {
+		// Invalid patterns are reported by Validate.
+		if patterns, err := o.piiPatterns(); err == nil {
+			core = &fieldRewriteCore{Core: core, rewrite: redactPII(patterns)}
+		}
+	}
+	if o.RawByteSizes {
+		core = &fieldRewriteCore{Core: core, rewrite: rawByteSizes}
+	}
+	if o.PanicDiagnostics {
+		core = &diagnosticsCore{Core: core}
+	}
+	if len(o.SampledMessages) > 0 {
+		core = newMessageSamplerCore(core, o.SampledMessages, st)
+	}
+	if o.SamplingKey != "" {
+		core = newKeyedSamplerCore(core, o.SamplingKey, o.SamplingKeyRate, o.SamplingKeyLRU, st)
+	}
+	if o.FirstThenSample > 0 {
+		core = newCallerSamplerCore(core, o.FirstThenSample, st)
+	}
+	if o.SuppressRepeatedContext {
+		core = newRepeatedContextCore(core)
+	}
+	if o.MaxFields > 0 {
+		core = &maxFieldsCore{Core: core, max: o.MaxFields}
+	}
+	if o.ErrorCooldown > 0 {
+		core = newCooldownCore(core, o.ErrorCooldown, o.clock(), st)
+	}
+	// Last, so that it compares the lines as they were logged.
+	if o.CollapseConsecutive {
+		core = &collapseCore{Core: core, clock: o.clock(), state: &collapseState{}, stats: st}
+	}
+
+	return core
+}
+
+// zapOptions returns the zap options derived from the options. syncFn flushes
+// the built logger.
+func (o *Options) zapOptions(errSink zapcore.WriteSyncer, syncFn func() error, fields []zap.Field) []zap.Option {
+	opts := []zap.Option{zap.ErrorOutput(errSink)}
+	if o.Development {
+		opts = append(opts, zap.Development())
+	}
+	if !o.DisableCaller {
+		opts = append(opts, zap.AddCaller())
+	}
+	if !o.DisableStacktrace {
+		opts = append(opts, zap.AddStacktrace(zapcore.PanicLevel))
+	}
+
+	if o.Clock != nil {
+		opts = append(opts, zap.WithClock(o.Clock))
+	}
+	if len(fields) > 0 {
+		opts = append(opts, zap.Fields(fields...))
+	}
+
+	return append(opts, o.terminalHooks(syncFn)...)
+}
+
+// clock returns the configured clock, defaulting to the system clock.
+func (o *Options) clock() zapcore.Clock {
+	if o.Clock != nil {
+		return o.Clock
+	}
+
+	return zapcore.DefaultClock
+}
+
+// initialFields returns the fields attached to every entry of the logger.
+func (o *Options) initialFields() []zap.Field {
+	keys := make([]string, 0, len(o.InitialFields))
+	for k := range o.InitialFields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fields := make([]zap.Field, 0, len(keys))
+	for _, k := range keys {
+		fields = append(fields, zap.Any(k, o.InitialFields[k]))
+	}
+	if o.FieldsFromEnv != "" {
+		fields = append(fields, envFields(o.FieldsFromEnv)...)
+	}
+	if o.RuntimeFields {
+		fields = append(fields, runtimeFields()...)
+	}
+	if o.SchemaVersion != "" {
+		fields = append(fields, zap.String("schema_version", o.SchemaVersion))
+	}
+	if len(o.FieldFuncs) > 0 {
+		fields = append(fields, o.funcFields()...)
+	}
+
+	return fields
+}
+
+// funcFields calls the FieldFuncs, sorted by key, and returns their results
+// as fields. A func returning an error or panicking gives a field holding the
+// error or the panic value instead, the logger is built all the same.
+func (o *Options) funcFields() []zap.Field {
+	keys := make([]string, 0, len(o.FieldFuncs))
+	for k := range o.FieldFuncs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fields := make([]zap.Field, 0, len(keys))
+	for _, k := range keys {
+		fields = append(fields, callFieldFunc(k, o.FieldFuncs[k]))
+	}
+
+	return fields
+}
+
+func callFieldFunc(key string, fn func() interface{}) (f zap.Field) {
+	defer func() {
+		if r := recover(); r != nil {
+			f = zap.String(key, fmt.Sprintf("panic: %v", r))
+		}
+	}()
+
+	v := fn()
+	if err, ok := v.(error); ok {
+		return zap.String(key, "error: "+err.Error())
+	}
+
+	return zap.Any(key, v)
+}
+
+// runtimeFields returns the fields describing the Go runtime and platform.
+// They are computed once, when the logger is built.
+func runtimeFields() []zap.Field {
+	return []zap.Field{
+		zap.String("go_version", runtime.Version()),
+		zap.String("goos", runtime.GOOS),
+		zap.String("goarch", runtime.GOARCH),
+		zap.Int("num_cpu", runtime.NumCPU()),
+	}
+}
+
+// envFields returns a field for every environment variable with the given
+// prefix, keyed by the lowercased rest of the variable name. For instance,
+// with the prefix "LOGFIELD_", LOGFIELD_SERVICE=payments becomes the field
+// service=payments.
+func envFields(prefix string) []zap.Field {
+	env := os.Environ()
+	sort.Strings(env)
+
+	var fields []zap.Field
+	for _, kv := range env {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, prefix) || len(name) == len(prefix) {
+			continue
+		}
+		fields = append(fields, zap.String(strings.ToLower(strings.TrimPrefix(name, prefix)), value))
+	}
+
+	return fields
+}
@@ -2,6 +2,9 @@ package log
 
 import (
 	"context"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 type key int
@@ -12,21 +15,90 @@ const (
 
 // WithContext returns a copy of context in which the log value is set.
 func WithContext(ctx context.Context) context.Context {
-	return std.WithContext(ctx)
+	return std().WithContext(ctx)
 }
 
+// WithContext returns a copy of ctx holding the logger. With
+// RequestScopedSampling, the stored logger gets its own sampler, so that the
+// repeated messages are throttled per context; storing it again in a derived
+// context keeps the same sampler. With TraceSampled, the logger stored in the
+// context of a sampled trace writes every level, debug included, so that
+// verbose logs are only written for the traced requests; the span must thus
+// be started before the logger is stored.
 func (l *zapLogger) WithContext(ctx context.Context) context.Context {
-	return context.WithValue(ctx, logContextKey, l)
+	if l.opts.TraceSampled != nil && !l.traceVerbose && l.opts.TraceSampled(ctx) {
+		verbose := l.derive(l.zapLogger.With(verboseField))
+		verbose.traceVerbose = true
+		l = verbose
+	}
+	if n := l.opts.RequestScopedSampling; n > 0 && !l.requestSampled {
+		var st *stats
+		if l.state != nil {
+			st = l.state.stats
+		}
+		scoped := l.derive(l.zapLogger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return newRequestSamplerCore(core, n, st)
+		})))
+		scoped.requestSampled = true
+
+		return context.WithValue(ctx, l.opts.contextKey(), scoped)
+	}
+
+	return context.WithValue(ctx, l.opts.contextKey(), l)
+}
+
+// LogContextDone logs with the std logger why ctx is done, if it is.
+func LogContextDone(ctx context.Context, msg string) {
+	if fields, done := contextDoneFields(ctx); done {
+		std().zapLogger.Warn(msg, fields...)
+	}
+}
+
+// LogContextDone logs at warn level why ctx is done, with its error as
+// ctx_err and its cause, as set by context.WithCancelCause or
+// context.WithTimeoutCause, as ctx_cause. It does nothing while ctx is not
+// done.
+func (l *zapLogger) LogContextDone(ctx context.Context, msg string) {
+	if fields, done := contextDoneFields(ctx); done {
+		l.zapLogger.Warn(msg, fields...)
+	}
+}
+
+func contextDoneFields(ctx context.Context) ([]zap.Field, bool) {
+	err := ctx.Err()
+	if err == nil {
+		return nil, false
+	}
+
+	return []zap.Field{
+		zap.String("ctx_err", err.Error()),
+		zap.String("ctx_cause", context.Cause(ctx).Error()),
+	}, true
 }
 
-// FromContext returns the value of the log key on the ctx.
+// FromContext returns the logger stored on the ctx under the ContextKey of
+// the std logger options.
 func FromContext(ctx context.Context) Logger {
+	return FromContextWithKey(ctx, std().opts.contextKey())
+}
+
+// FromContextWithKey returns the logger stored on the ctx under the given
+// key, which is the ContextKey of the options the logger was built with.
+func FromContextWithKey(ctx context.Context, key interface{}) Logger {
 	if ctx != nil {
-		logger := ctx.Value(logContextKey)
-		if logger != nil {
-			return logger.(Logger)
+		if logger, ok := ctx.Value(key).(Logger); ok {
+			return logger
 		}
 	}
 
 	return WithName("Unknown-Context")
 }
+
+// contextKey returns the key loggers are stored under in a context.
+func (o *Options) contextKey() interface{} {
+	if o.ContextKey != nil {
+		return o.ContextKey
+	}
+
+	return logContextKey
+}
@@ -0,0 +1,690 @@
+package log
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// repeatedContextRestoreInterval is the number of consecutive entries for
+// which repeated fields are suppressed before they are logged in full again.
+const repeatedContextRestoreInterval = 100
+
+var sameContextFields = []zapcore.Field{zap.String("context", "(same context)")}
+
+// repeatedContextCore is a zapcore.Core which replaces the fields of an entry
+// with a "(same context)" marker when they are identical to the fields of the
+// previous entry. Every repeatedContextRestoreInterval entries the full fields
+// are logged again, so that the context can still be found after a rotation.
+//
+// The state is kept per logger instance: loggers derived with With get their
+// own state and are compared independently.
+type repeatedContextCore struct {
+	zapcore.Core
+
+	mu      sync.Mutex
+	last    []zapcore.Field
+	repeats int
+	// hasLast reports whether last is not empty, so that entries without
+	// fields can skip the lock as long as there is nothing to reset.
+	hasLast atomic.Bool
+}
+
+func newRepeatedContextCore(core zapcore.Core) zapcore.Core {
+	return &repeatedContextCore{Core: core}
+}
+
+func (c *repeatedContextCore) With(fields []zapcore.Field) zapcore.Core {
+	return newRepeatedContextCore(c.Core.With(fields))
+}
+
+func (c *repeatedContextCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *repeatedContextCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if len(fields) == 0 && !c.hasLast.Load() {
+		return c.Core.Write(ent, fields)
+	}
+
+	c.mu.Lock()
+	same := len(fields) > 0 && c.repeats < repeatedContextRestoreInterval && fieldsEqual(c.last, fields)
+	if same {
+		c.repeats++
+	} else {
+		c.last = append(c.last[:0], fields...)
+		c.repeats = 0
+		c.hasLast.Store(len(c.last) > 0)
+	}
+	c.mu.Unlock()
+
+	if same {
+		fields = sameContextFields
+	}
+
+	return c.Core.Write(ent, fields)
+}
+
+// collapseCore is a zapcore.Core collapsing consecutive identical lines, as
+// uniq does: an entry with the same level, message, context and fields as the
+// previous one is dropped and counted, and a "(repeated N times)" entry is
+// written with the context and fields of the repeated line once a different
+// line is logged or the core is synced. The loggers derived with With share the
+// state, their lines being consecutive in the output as well.
+type collapseCore struct {
+	zapcore.Core
+	clock   zapcore.Clock
+	context []zapcore.Field
+	state   *collapseState
+	stats   *stats
+}
+
+type collapseState struct {
+	mu      sync.Mutex
+	last    collapsedLine
+	repeats int
+}
+
+// collapsedLine is the last line written by a collapseCore.
+type collapsedLine struct {
+	core    zapcore.Core
+	ent     zapcore.Entry
+	context []zapcore.Field
+	fields  []zapcore.Field
+}
+
+func (l *collapsedLine) matches(c *collapseCore, ent zapcore.Entry, fields []zapcore.Field) bool {
+	return l.core != nil && l.ent.Level == ent.Level && l.ent.Message == ent.Message &&
+		fieldsEqual(l.context, c.context) && fieldsEqual(l.fields, fields)
+}
+
+func (c *collapseCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.Core = c.Core.With(fields)
+	clone.context = append(c.context[:len(c.context):len(c.context)], fields...)
+
+	return &clone
+}
+
+func (c *collapseCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *collapseCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	c.state.mu.Lock()
+	if c.state.last.matches(c, ent, fields) {
+		c.state.repeats++
+		c.state.mu.Unlock()
+		c.stats.deduped.inc(ent.Level)
+
+		return nil
+	}
+	last, repeats := c.state.last, c.state.repeats
+	c.state.last = collapsedLine{
+		core:    c.Core,
+		ent:     ent,
+		context: c.context,
+		fields:  append([]zapcore.Field(nil), fields...),
+	}
+	c.state.repeats = 0
+	c.state.mu.Unlock()
+
+	if repeats > 0 {
+		_ = writeRepeated(last, repeats, ent.Time)
+	}
+
+	return c.Core.Write(ent, fields)
+}
+
+func (c *collapseCore) Sync() error {
+	c.state.mu.Lock()
+	last, repeats := c.state.last, c.state.repeats
+	c.state.repeats = 0
+	c.state.mu.Unlock()
+
+	if repeats > 0 {
+		_ = writeRepeated(last, repeats, c.clock.Now())
+	}
+
+	return c.Core.Sync()
+}
+
+// writeRepeated writes the summary of the repeats of the line at t: its entry,
+// logger name and caller included, with its fields and the message replaced.
+func writeRepeated(line collapsedLine, repeats int, t time.Time) error {
+	ent := line.ent
+	ent.Time = t
+	ent.Message = fmt.Sprintf("(repeated %d times)", repeats)
+
+	return line.core.Write(ent, line.fields)
+}
+
+func fieldsEqual(a, b []zapcore.Field) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equals(b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// levelFilterCore restricts a core to the levels accepted by enab. Unlike the
+// level of an ioCore it is also checked in Write, because core wrappers write
+// to a tee directly and zapcore's tee writes to all of its cores.
+type levelFilterCore struct {
+	zapcore.Core
+	enab zapcore.LevelEnabler
+}
+
+func newLevelFilterCore(core zapcore.Core, enab zapcore.LevelEnabler) zapcore.Core {
+	return &levelFilterCore{Core: core, enab: enab}
+}
+
+func (c *levelFilterCore) Enabled(lvl zapcore.Level) bool {
+	return c.enab.Enabled(lvl) && c.Core.Enabled(lvl)
+}
+
+func (c *levelFilterCore) With(fields []zapcore.Field) zapcore.Core {
+	return newLevelFilterCore(c.Core.With(fields), c.enab)
+}
+
+func (c *levelFilterCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.enab.Enabled(ent.Level) {
+		return c.Core.Check(ent, ce)
+	}
+
+	return ce
+}
+
+func (c *levelFilterCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if !c.enab.Enabled(ent.Level) {
+		return nil
+	}
+
+	return c.Core.Write(ent, fields)
+}
+
+// verboseField is the marker field making the level gate of a child logger
+// enable every level. It is a skipped field, encoded as nothing should it
+// reach an encoder.
+var verboseField = zap.Field{Type: zapcore.SkipType, Interface: verboseMarker{}}
+
+type verboseMarker struct{}
+
+// gateCore is the level gate of a logger, in front of the sampler. It drops
+// every entry while paused and the entries at the levels enab rejects
+// otherwise, unless the core is verbose. A verbose gate is derived by adding
+// the verboseField with With, which, unlike a wrapper, keeps the fields of the
+// logger and the wrappers already around the gate.
+type gateCore struct {
+	zapcore.Core
+	paused  *atomic.Bool
+	enab    zapcore.LevelEnabler
+	verbose bool
+}
+
+func (c *gateCore) enabled(lvl zapcore.Level) bool {
+	return !c.paused.Load() && (c.verbose || c.enab.Enabled(lvl))
+}
+
+func (c *gateCore) Enabled(lvl zapcore.Level) bool {
+	return c.enabled(lvl) && c.Core.Enabled(lvl)
+}
+
+func (c *gateCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	kept := make([]zapcore.Field, 0, len(fields))
+	for _, f := range fields {
+		if f.Type == zapcore.SkipType && f.Interface == (verboseMarker{}) {
+			clone.verbose = true
+
+			continue
+		}
+		kept = append(kept, f)
+	}
+	clone.Core = c.Core.With(kept)
+
+	return &clone
+}
+
+func (c *gateCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.enabled(ent.Level) {
+		return c.Core.Check(ent, ce)
+	}
+
+	return ce
+}
+
+func (c *gateCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if !c.enabled(ent.Level) {
+		return nil
+	}
+
+	return c.Core.Write(ent, fields)
+}
+
+// maxFieldsCore is a zapcore.Core which keeps at most max fields of an entry,
+// marking the entries it truncated.
+type maxFieldsCore struct {
+	zapcore.Core
+	max int
+}
+
+func (c *maxFieldsCore) With(fields []zapcore.Field) zapcore.Core {
+	return &maxFieldsCore{Core: c.Core.With(fields), max: c.max}
+}
+
+func (c *maxFieldsCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *maxFieldsCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if len(fields) > c.max {
+		truncated := make([]zapcore.Field, 0, c.max+1)
+		fields = append(append(truncated, fields[:c.max]...), zap.Bool("fields_truncated", true))
+	}
+
+	return c.Core.Write(ent, fields)
+}
+
+// diagnosticsCore is a zapcore.Core adding runtime diagnostics to Panic and
+// Fatal entries: the number of goroutines and memory statistics, plus the
+// stacks of all the goroutines for Fatal. They are captured while the entry is
+// written, so before the logger panics or exits.
+type diagnosticsCore struct {
+	zapcore.Core
+}
+
+func (c *diagnosticsCore) With(fields []zapcore.Field) zapcore.Core {
+	return &diagnosticsCore{Core: c.Core.With(fields)}
+}
+
+func (c *diagnosticsCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *diagnosticsCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if ent.Level < zapcore.PanicLevel {
+		return c.Core.Write(ent, fields)
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	fields = append(fields[:len(fields):len(fields)],
+		zap.Int("goroutines", runtime.NumGoroutine()),
+		zap.Dict("memstats",
+			zap.Uint64("heap_alloc", mem.HeapAlloc),
+			zap.Uint64("heap_sys", mem.HeapSys),
+			zap.Uint64("heap_objects", mem.HeapObjects),
+			zap.Uint64("sys", mem.Sys),
+			zap.Uint32("num_gc", mem.NumGC),
+		),
+	)
+	if ent.Level == zapcore.FatalLevel {
+		fields = append(fields, zap.String("goroutine_dump", goroutineDump()))
+	}
+
+	return c.Core.Write(ent, fields)
+}
+
+// goroutineDump returns the stacks of all the goroutines.
+func goroutineDump() string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// uptimeCore is a zapcore.Core adding the milliseconds elapsed between start
+// and the entry time.
+type uptimeCore struct {
+	zapcore.Core
+	start time.Time
+}
+
+func (c *uptimeCore) With(fields []zapcore.Field) zapcore.Core {
+	return &uptimeCore{Core: c.Core.With(fields), start: c.start}
+}
+
+func (c *uptimeCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *uptimeCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	uptime := ent.Time.Sub(c.start).Milliseconds()
+	fields = append(fields[:len(fields):len(fields)], zap.Int64("uptime_ms", uptime))
+
+	return c.Core.Write(ent, fields)
+}
+
+// sequenceCore is a zapcore.Core numbering the entries it writes in a seq
+// field, starting from 1. The cores derived with With share the counter, so
+// the loggers derived from a logger share its sequence.
+type sequenceCore struct {
+	zapcore.Core
+	seq *atomic.Uint64
+}
+
+func (c *sequenceCore) With(fields []zapcore.Field) zapcore.Core {
+	return &sequenceCore{Core: c.Core.With(fields), seq: c.seq}
+}
+
+func (c *sequenceCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *sequenceCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	fields = append(fields[:len(fields):len(fields)], zap.Uint64("seq", c.seq.Add(1)))
+
+	return c.Core.Write(ent, fields)
+}
+
+// fieldRewriteCore is a zapcore.Core rewriting the context and entry fields
+// with rewrite before handing them to the wrapped core. rewrite must not
+// modify the slice it is given.
+type fieldRewriteCore struct {
+	zapcore.Core
+	rewrite func([]zapcore.Field) []zapcore.Field
+}
+
+func (c *fieldRewriteCore) With(fields []zapcore.Field) zapcore.Core {
+	return &fieldRewriteCore{Core: c.Core.With(c.rewrite(fields)), rewrite: c.rewrite}
+}
+
+func (c *fieldRewriteCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *fieldRewriteCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(ent, c.rewrite(fields))
+}
+
+// rawByteSizes adds the raw number of bytes after every ByteSize field.
+func rawByteSizes(fields []zapcore.Field) []zapcore.Field {
+	var out []zapcore.Field
+	for i, f := range fields {
+		size, ok := f.Interface.(byteSize)
+		if !ok || f.Type != zapcore.StringerType {
+			if out != nil {
+				out = append(out, f)
+			}
+
+			continue
+		}
+		if out == nil {
+			out = append(make([]zapcore.Field, 0, len(fields)+1), fields[:i]...)
+		}
+		out = append(out, f, zap.Int64(f.Key+"_bytes", int64(size)))
+	}
+	if out == nil {
+		return fields
+	}
+
+	return out
+}
+
+// levelRouterCore is a zapcore.Core handing the entries at level or above to
+// high and the other ones to low. It lets some levels bypass the sampler.
+type levelRouterCore struct {
+	low, high zapcore.Core
+	level     zapcore.Level
+}
+
+func (c *levelRouterCore) Enabled(lvl zapcore.Level) bool {
+	if lvl >= c.level {
+		return c.high.Enabled(lvl)
+	}
+
+	return c.low.Enabled(lvl)
+}
+
+func (c *levelRouterCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelRouterCore{low: c.low.With(fields), high: c.high.With(fields), level: c.level}
+}
+
+func (c *levelRouterCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if ent.Level >= c.level {
+		return c.high.Check(ent, ce)
+	}
+
+	return c.low.Check(ent, ce)
+}
+
+func (c *levelRouterCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if ent.Level >= c.level {
+		return c.high.Write(ent, fields)
+	}
+
+	return c.low.Write(ent, fields)
+}
+
+func (c *levelRouterCore) Sync() error {
+	// Both cores share the same outputs.
+	return c.high.Sync()
+}
+
+// stacktraceDepthCore is a zapcore.Core keeping the top depth frames of the
+// entry stacktraces, followed by the number of frames removed.
+type stacktraceDepthCore struct {
+	zapcore.Core
+	depth int
+}
+
+func (c *stacktraceDepthCore) With(fields []zapcore.Field) zapcore.Core {
+	return &stacktraceDepthCore{Core: c.Core.With(fields), depth: c.depth}
+}
+
+func (c *stacktraceDepthCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *stacktraceDepthCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	ent.Stack = truncateStacktrace(ent.Stack, c.depth)
+
+	return c.Core.Write(ent, fields)
+}
+
+// truncateStacktrace keeps the top depth frames of a zap stacktrace, in which
+// every frame is a function line followed by a tab indented file:line one.
+func truncateStacktrace(stack string, depth int) string {
+	lines := strings.Split(stack, "\n")
+	frames := (len(lines) + 1) / 2
+	if frames <= depth {
+		return stack
+	}
+
+	return strings.Join(lines[:2*depth], "\n") + fmt.Sprintf("\n...%d more", frames-depth)
+}
+
+// structuredStacktraceCore is a zapcore.Core writing the entry stacktraces as
+// an array of {function, file, line} frames instead of a multiline string. The
+// number of frames removed by StacktraceDepth, if any, goes to a separate
+// stacktrace_truncated field.
+type structuredStacktraceCore struct {
+	zapcore.Core
+}
+
+func (c *structuredStacktraceCore) With(fields []zapcore.Field) zapcore.Core {
+	return &structuredStacktraceCore{Core: c.Core.With(fields)}
+}
+
+func (c *structuredStacktraceCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *structuredStacktraceCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if ent.Stack == "" {
+		return c.Core.Write(ent, fields)
+	}
+
+	frames, more := parseStacktrace(ent.Stack)
+	ent.Stack = ""
+	fields = append(fields[:len(fields):len(fields)], zap.Array("stacktrace", frames))
+	if more > 0 {
+		fields = append(fields, zap.Int("stacktrace_truncated", more))
+	}
+
+	return c.Core.Write(ent, fields)
+}
+
+// stackFrame is a frame of a stacktrace.
+type stackFrame struct {
+	function string
+	file     string
+	line     int
+}
+
+func (f stackFrame) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("function", f.function)
+	enc.AddString("file", f.file)
+	enc.AddInt("line", f.line)
+
+	return nil
+}
+
+type stackFrames []stackFrame
+
+func (fs stackFrames) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, f := range fs {
+		if err := enc.AppendObject(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseStacktrace parses the frames of a zap stacktrace, along with the number
+// of frames removed by truncateStacktrace.
+func parseStacktrace(stack string) (stackFrames, int) {
+	lines := strings.Split(stack, "\n")
+	frames := make(stackFrames, 0, (len(lines)+1)/2)
+	more := 0
+	for i := 0; i < len(lines); i++ {
+		if n, err := fmt.Sscanf(lines[i], "...%d more", &more); err == nil && n == 1 {
+			continue
+		}
+		frame := stackFrame{function: lines[i]}
+		if i+1 < len(lines) && strings.HasPrefix(lines[i+1], "\t") {
+			i++
+			location := strings.TrimPrefix(lines[i], "\t")
+			frame.file = location
+			if sep := strings.LastIndexByte(location, ':'); sep >= 0 {
+				if line, err := strconv.Atoi(location[sep+1:]); err == nil {
+					frame.file, frame.line = location[:sep], line
+				}
+			}
+		}
+		frames = append(frames, frame)
+	}
+
+	return frames, more
+}
+
+// maskedStringEnds is the number of runes kept at both ends of the strings
+// masked by MaskLongStrings.
+const maskedStringEnds = 4
+
+// maskLongStrings returns a field rewrite masking the middle of the string
+// values longer than threshold runes.
+func maskLongStrings(threshold int) func([]zapcore.Field) []zapcore.Field {
+	if threshold < 2*maskedStringEnds {
+		threshold = 2 * maskedStringEnds
+	}
+
+	return func(fields []zapcore.Field) []zapcore.Field {
+		var out []zapcore.Field
+		for i, f := range fields {
+			if f.Type != zapcore.StringType || utf8.RuneCountInString(f.String) <= threshold {
+				continue
+			}
+			if out == nil {
+				out = append(make([]zapcore.Field, 0, len(fields)), fields...)
+			}
+			runes := []rune(f.String)
+			out[i].String = string(runes[:maskedStringEnds]) + "…" + string(runes[len(runes)-maskedStringEnds:])
+		}
+		if out == nil {
+			return fields
+		}
+
+		return out
+	}
+}
+
+// numericLevelCore is a zapcore.Core adding the level of the entries as an
+// integer, next to the textual level of the encoder.
+type numericLevelCore struct {
+	zapcore.Core
+	key string
+}
+
+func (c *numericLevelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &numericLevelCore{Core: c.Core.With(fields), key: c.key}
+}
+
+func (c *numericLevelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *numericLevelCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	fields = append(fields[:len(fields):len(fields)], zap.Int8(c.key, int8(ent.Level)))
+
+	return c.Core.Write(ent, fields)
+}
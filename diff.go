@@ -0,0 +1,194 @@
+package log
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// diffTag renames a struct field in diffs, "-" leaves it out.
+	diffTag = "log"
+	// redactTag hides the values of a struct field in diffs when set to "true".
+	redactTag = "redact"
+
+	redactedValue = "[REDACTED]"
+)
+
+// Diff logs at info level the fields which differ between before and after.
+func Diff(msg string, before, after interface{}) {
+	std().zapLogger.Info(msg, diffField(before, after))
+}
+
+// Diff logs at info level the fields which differ between before and after,
+// as a changes array of {field, old, new} objects. Structs are compared field
+// by field, nested structs and maps giving dotted field names. The log tag
+// renames a field, or leaves it out with "-", and a redact:"true" tag hides
+// its values. Map keys only present on one side are reported as added or
+// removed.
+func (l *zapLogger) Diff(msg string, before, after interface{}) {
+	l.zapLogger.Info(msg, diffField(before, after))
+}
+
+func diffField(before, after interface{}) zap.Field {
+	var changes changes
+	changes.diff("", reflect.ValueOf(before), reflect.ValueOf(after), false)
+
+	return zap.Array("changes", changes)
+}
+
+// change is a difference found by Diff.
+type change struct {
+	field    string
+	old, new interface{}
+	// added and removed mark map keys only present in after or before.
+	added, removed bool
+}
+
+func (c change) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("field", c.field)
+	if !c.added {
+		if err := enc.AddReflected("old", c.old); err != nil {
+			return err
+		}
+	}
+	if !c.removed {
+		if err := enc.AddReflected("new", c.new); err != nil {
+			return err
+		}
+	}
+	if c.added {
+		enc.AddBool("added", true)
+	}
+	if c.removed {
+		enc.AddBool("removed", true)
+	}
+
+	return nil
+}
+
+type changes []change
+
+func (cs changes) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, c := range cs {
+		if err := enc.AppendObject(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cs *changes) diff(path string, before, after reflect.Value, redact bool) {
+	before, after = indirect(before), indirect(after)
+
+	switch {
+	case !before.IsValid() && !after.IsValid():
+		return
+	case !before.IsValid() || !after.IsValid() || before.Type() != after.Type():
+	case before.Kind() == reflect.Struct && hasExportedFields(before.Type()):
+		cs.diffStruct(path, before, after, redact)
+
+		return
+	case before.Kind() == reflect.Map:
+		cs.diffMap(path, before, after, redact)
+
+		return
+	case reflect.DeepEqual(before.Interface(), after.Interface()):
+		return
+	}
+
+	*cs = append(*cs, change{field: path, old: value(before, redact), new: value(after, redact)})
+}
+
+func (cs *changes) diffStruct(path string, before, after reflect.Value, redact bool) {
+	t := before.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup(diffTag); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		cs.diff(join(path, name), before.Field(i), after.Field(i), redact || f.Tag.Get(redactTag) == "true")
+	}
+}
+
+func (cs *changes) diffMap(path string, before, after reflect.Value, redact bool) {
+	for _, key := range sortedKeys(before) {
+		name := join(path, fmt.Sprint(key.Interface()))
+		if v := after.MapIndex(key); v.IsValid() {
+			cs.diff(name, before.MapIndex(key), v, redact)
+		} else {
+			*cs = append(*cs, change{field: name, old: value(before.MapIndex(key), redact), removed: true})
+		}
+	}
+	for _, key := range sortedKeys(after) {
+		if !before.MapIndex(key).IsValid() {
+			name := join(path, fmt.Sprint(key.Interface()))
+			*cs = append(*cs, change{field: name, new: value(after.MapIndex(key), redact), added: true})
+		}
+	}
+}
+
+// hasExportedFields reports whether the struct type t has exported fields.
+// Structs without any, such as time.Time, are compared as a whole.
+func hasExportedFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).IsExported() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortedKeys returns the keys of the map m, sorted by their string form so
+// that diffs are stable.
+func sortedKeys(m reflect.Value) []reflect.Value {
+	keys := m.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+
+	return keys
+}
+
+// indirect dereferences pointers and interfaces, nil ones give the zero Value.
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		v = v.Elem()
+	}
+
+	return v
+}
+
+func value(v reflect.Value, redact bool) interface{} {
+	v = indirect(v)
+	switch {
+	case !v.IsValid():
+		return nil
+	case redact:
+		return redactedValue
+	default:
+		return v.Interface()
+	}
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+
+	return path + "." + name
+}
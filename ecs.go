@@ -0,0 +1,72 @@
+package log
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+// ecsVersion is the version of the Elastic Common Schema written by the ecs
+// format.
+const ecsVersion = "8.11.0"
+
+// ecsEncoderConfig adapts an encoder configuration to the Elastic Common
+// Schema field names. Elasticsearch nests the dotted keys, so log.level ends
+// up as the level field of the log object.
+func ecsEncoderConfig(cfg zapcore.EncoderConfig) zapcore.EncoderConfig {
+	cfg.TimeKey = "@timestamp"
+	cfg.LevelKey = "log.level"
+	cfg.NameKey = "log.logger"
+	cfg.CallerKey = "log.origin.file.name"
+	cfg.MessageKey = "message"
+	cfg.StacktraceKey = "error.stack_trace"
+	cfg.EncodeLevel = zapcore.LowercaseLevelEncoder
+	cfg.EncodeTime = func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
+		enc.AppendString(t.UTC().Format(time.RFC3339Nano))
+	}
+
+	return cfg
+}
+
+// ecsEncoder is a JSON encoder writing the ecs.version field and the error
+// fields as ECS error.message.
+type ecsEncoder struct {
+	zapcore.Encoder
+}
+
+func newECSEncoder(cfg zapcore.EncoderConfig) zapcore.Encoder {
+	return &ecsEncoder{Encoder: zapcore.NewJSONEncoder(ecsEncoderConfig(cfg))}
+}
+
+func (e *ecsEncoder) Clone() zapcore.Encoder {
+	return &ecsEncoder{Encoder: e.Encoder.Clone()}
+}
+
+// AddString renames the error fields added through With.
+func (e *ecsEncoder) AddString(key, value string) {
+	e.Encoder.AddString(ecsKey(key), value)
+}
+
+func (e *ecsEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	out := make([]zapcore.Field, 0, len(fields)+1)
+	out = append(out, zap.String("ecs.version", ecsVersion))
+	for _, f := range fields {
+		if f.Type == zapcore.ErrorType {
+			f.Key = ecsKey(f.Key)
+		}
+		out = append(out, f)
+	}
+
+	return e.Encoder.EncodeEntry(ent, out)
+}
+
+// ecsKey maps the keys of our standard fields to their ECS name.
+func ecsKey(key string) string {
+	if key == "error" {
+		return "error.message"
+	}
+
+	return key
+}
@@ -0,0 +1,66 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// encodeErrorValue replaces the value of the fields which failed to encode.
+const encodeErrorValue = "encode_error"
+
+// recoverEncodeErrors returns a rewrite encoding the object, array and
+// reflected fields beforehand, so that a field whose marshaler fails or panics
+// is replaced by an "encode_error" string instead of breaking the entry. The
+// failures are reported to errOut.
+func recoverEncodeErrors(errOut zapcore.WriteSyncer) func([]zapcore.Field) []zapcore.Field {
+	return func(fields []zapcore.Field) []zapcore.Field {
+		var out []zapcore.Field
+		for i, f := range fields {
+			err := tryEncode(f)
+			if err == nil {
+				if out != nil {
+					out = append(out, f)
+				}
+
+				continue
+			}
+			fmt.Fprintf(errOut, "encode error for field %q: %v\n", f.Key, err)
+			_ = errOut.Sync()
+			if out == nil {
+				out = append(make([]zapcore.Field, 0, len(fields)), fields[:i]...)
+			}
+			out = append(out, zap.String(f.Key, encodeErrorValue))
+		}
+		if out == nil {
+			return fields
+		}
+
+		return out
+	}
+}
+
+// tryEncode encodes the field if it holds a user marshaler, returning the
+// error it failed with or the value it panicked with.
+func tryEncode(f zapcore.Field) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	switch f.Type {
+	case zapcore.ObjectMarshalerType, zapcore.InlineMarshalerType:
+		return f.Interface.(zapcore.ObjectMarshaler).MarshalLogObject(zapcore.NewMapObjectEncoder())
+	case zapcore.ArrayMarshalerType:
+		return zapcore.NewMapObjectEncoder().AddArray(f.Key, f.Interface.(zapcore.ArrayMarshaler))
+	case zapcore.ReflectType:
+		_, err := json.Marshal(f.Interface)
+
+		return err
+	default:
+		return nil
+	}
+}
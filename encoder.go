@@ -1,14 +1,481 @@
 package log
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
 	"go.uber.org/zap/zapcore"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
+)
+
+const (
+	shortCallerEncoder    = "short"
+	fullCallerEncoder     = "full"
+	twoLevelCallerEncoder = "twolevel"
+
+	defaultTimeLayout = "2006-01-02 15:04:05.000"
+	// epochNanoTimeFormat writes the time as the integer number of nanoseconds
+	// since the Unix epoch.
+	epochNanoTimeFormat = "epochnano"
 )
 
 func timeEncoder(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
-	enc.AppendString(t.Format("2006-01-02 15:04:05.000"))
+	enc.AppendString(t.Format(defaultTimeLayout))
+}
+
+// timeEncoderFor returns the encoder of the TimeFormat format: epochnano or a
+// time layout, the default layout when empty.
+func timeEncoderFor(format string) zapcore.TimeEncoder {
+	switch format {
+	case "":
+		return timeEncoder
+	case epochNanoTimeFormat:
+		return func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
+			enc.AppendInt64(t.UnixNano())
+		}
+	default:
+		return zapcore.TimeEncoderOfLayout(format)
+	}
+}
+
+// validTimeLayout reports whether layout holds at least one element of the
+// reference time, a layout without any would write the same text every time.
+// epochnano is numeric and cannot be mixed with a layout.
+func validTimeLayout(layout string) bool {
+	return !strings.Contains(layout, epochNanoTimeFormat) && time.Unix(0, 0).UTC().Format(layout) != layout
 }
 
 func milliSecondsDurationEncoder(d time.Duration, enc zapcore.PrimitiveArrayEncoder) {
 	enc.AppendFloat64(float64(d) / float64(time.Millisecond))
 }
+
+// TwoLevelCallerEncoder serializes a caller in pkg/subpkg/file.go:line format,
+// keeping the two directories above the file. It sits between
+// zapcore.ShortCallerEncoder and zapcore.FullCallerEncoder, enough to tell
+// apart files with the same name in a monorepo.
+func TwoLevelCallerEncoder(caller zapcore.EntryCaller, enc zapcore.PrimitiveArrayEncoder) {
+	if !caller.Defined {
+		enc.AppendString("undefined")
+
+		return
+	}
+
+	path := caller.File
+	for i, idx := 0, len(path); i < 3; i++ {
+		idx = strings.LastIndexByte(path[:idx], '/')
+		if idx == -1 {
+			break
+		}
+		if i == 2 {
+			path = path[idx+1:]
+		}
+	}
+	// Drop the root of the short absolute paths, such as /main.go.
+	path = strings.TrimPrefix(path, "/")
+	enc.AppendString(path + ":" + strconv.Itoa(caller.Line))
+}
+
+// callerEncoder returns the caller encoder with the given name.
+func callerEncoder(name string) zapcore.CallerEncoder {
+	switch name {
+	case fullCallerEncoder:
+		return zapcore.FullCallerEncoder
+	case twoLevelCallerEncoder:
+		return TwoLevelCallerEncoder
+	default:
+		return zapcore.ShortCallerEncoder
+	}
+}
+
+// multilineIndent prefixes every field line of the multiline console encoder.
+const multilineIndent = "    "
+
+// bufferPool provides the buffers of the encoders wrapping zap ones.
+var bufferPool = buffer.NewPool()
+
+// multilineEncoder is a console encoder printing the entry on a first line and
+// then every field indented on its own line. Fields are accumulated by the
+// embedded JSON encoder, configured to output nothing but the fields, and
+// unpacked in order when the entry is encoded.
+type multilineEncoder struct {
+	zapcore.Encoder // accumulates the context fields
+
+	head zapcore.Encoder
+	cfg  zapcore.EncoderConfig
+}
+
+// newMultilineEncoder creates a multiline console encoder from cfg.
+func newMultilineEncoder(cfg zapcore.EncoderConfig) zapcore.Encoder {
+	head := cfg
+	head.StacktraceKey = ""
+
+	fields := cfg
+	fields.MessageKey = ""
+	fields.LevelKey = ""
+	fields.TimeKey = ""
+	fields.NameKey = ""
+	fields.CallerKey = ""
+	fields.FunctionKey = ""
+	fields.StacktraceKey = ""
+
+	return &multilineEncoder{
+		Encoder: zapcore.NewJSONEncoder(fields),
+		head:    zapcore.NewConsoleEncoder(head),
+		cfg:     cfg,
+	}
+}
+
+func (enc *multilineEncoder) Clone() zapcore.Encoder {
+	return &multilineEncoder{
+		Encoder: enc.Encoder.Clone(),
+		head:    enc.head,
+		cfg:     enc.cfg,
+	}
+}
+
+func (enc *multilineEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	head, err := enc.head.EncodeEntry(ent, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer head.Free()
+
+	encoded, err := enc.Encoder.EncodeEntry(ent, fields)
+	if err != nil {
+		return nil, err
+	}
+	defer encoded.Free()
+
+	lineEnding := enc.cfg.LineEnding
+	if lineEnding == "" {
+		lineEnding = zapcore.DefaultLineEnding
+	}
+
+	line := bufferPool.Get()
+	line.AppendString(strings.TrimSuffix(head.String(), lineEnding))
+	if err := appendFieldLines(line, encoded.Bytes()); err != nil {
+		line.Free()
+
+		return nil, err
+	}
+	if ent.Stack != "" && enc.cfg.StacktraceKey != "" {
+		line.AppendByte('\n')
+		line.AppendString(ent.Stack)
+	}
+	line.AppendString(lineEnding)
+
+	return line, nil
+}
+
+// appendFieldLines appends the members of the JSON object obj to buf as
+// indented "key: value" lines, in their encoded order. String values are
+// unquoted, other values are kept as JSON.
+func appendFieldLines(buf *buffer.Buffer, obj []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(obj))
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+
+		buf.AppendByte('\n')
+		buf.AppendString(multilineIndent)
+		buf.AppendString(fmt.Sprint(key))
+		buf.AppendString(": ")
+		var s string
+		if json.Unmarshal(value, &s) == nil {
+			buf.AppendString(s)
+		} else {
+			buf.Write(value)
+		}
+	}
+
+	return nil
+}
+
+// recordSeparator starts every record of a JSON text sequence (RFC 7464).
+const recordSeparator = 0x1E
+
+// jsonSeqEncoder prefixes the records of the wrapped encoder with the RFC 7464
+// record separator. Records still end with a line feed, as the RFC
+// recommends.
+type jsonSeqEncoder struct {
+	zapcore.Encoder
+}
+
+func (enc *jsonSeqEncoder) Clone() zapcore.Encoder {
+	return &jsonSeqEncoder{Encoder: enc.Encoder.Clone()}
+}
+
+func (enc *jsonSeqEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	encoded, err := enc.Encoder.EncodeEntry(ent, fields)
+	if err != nil {
+		return nil, err
+	}
+	defer encoded.Free()
+
+	record := bufferPool.Get()
+	record.AppendByte(recordSeparator)
+	_, _ = record.Write(encoded.Bytes())
+
+	return record, nil
+}
+
+// int64StringEncoder encodes the int64 and uint64 fields as strings, so that
+// JavaScript consumers, whose numbers are float64, do not lose the precision
+// of large IDs. Only the fields of the entry and of the logger context are
+// converted, not the ones nested in objects or arrays.
+type int64StringEncoder struct {
+	zapcore.Encoder
+}
+
+func (enc *int64StringEncoder) Clone() zapcore.Encoder {
+	return &int64StringEncoder{Encoder: enc.Encoder.Clone()}
+}
+
+func (enc *int64StringEncoder) AddInt64(key string, value int64) {
+	enc.Encoder.AddString(key, strconv.FormatInt(value, 10))
+}
+
+func (enc *int64StringEncoder) AddUint64(key string, value uint64) {
+	enc.Encoder.AddString(key, strconv.FormatUint(value, 10))
+}
+
+func (enc *int64StringEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	var out []zapcore.Field
+	for i, f := range fields {
+		var s string
+		switch f.Type {
+		case zapcore.Int64Type:
+			s = strconv.FormatInt(f.Integer, 10)
+		case zapcore.Uint64Type:
+			s = strconv.FormatUint(uint64(f.Integer), 10)
+		default:
+			continue
+		}
+		if out == nil {
+			out = append(make([]zapcore.Field, 0, len(fields)), fields...)
+		}
+		out[i] = zap.String(f.Key, s)
+	}
+	if out == nil {
+		out = fields
+	}
+
+	return enc.Encoder.EncodeEntry(ent, out)
+}
+
+// colorCodes maps the color names accepted by LevelColors to their ANSI
+// foreground codes.
+var colorCodes = map[string]int{
+	"black":   30,
+	"red":     31,
+	"green":   32,
+	"yellow":  33,
+	"blue":    34,
+	"magenta": 35,
+	"cyan":    36,
+	"white":   37,
+}
+
+// coloredLevelEncoder returns a level encoder like
+// zapcore.CapitalColorLevelEncoder, using the given color names for some
+// levels, parsed with parse. Names must have been validated.
+func coloredLevelEncoder(colors map[string]string, parse func(string) (Level, error)) zapcore.LevelEncoder {
+	colored := make(map[zapcore.Level]string, len(colors))
+	for name, color := range colors {
+		lvl, err := parse(name)
+		if err != nil {
+			continue
+		}
+		colored[lvl] = fmt.Sprintf("\x1b[%dm%s\x1b[0m", colorCodes[strings.ToLower(color)], lvl.CapitalString())
+	}
+
+	return func(lvl zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+		if s, ok := colored[lvl]; ok {
+			enc.AppendString(s)
+
+			return
+		}
+		zapcore.CapitalColorLevelEncoder(lvl, enc)
+	}
+}
+
+// maxPlaceholderMessageBytes bounds the message kept by the placeholder of an
+// oversized entry.
+const maxPlaceholderMessageBytes = 256
+
+// maxEntryEncoder replaces the entries whose encoding is larger than max bytes
+// by a placeholder carrying only their message, truncated, and encoded size.
+// The placeholder is encoded without the context fields, which may be the
+// oversized ones.
+//
+// The size of the strings and bytes of an entry and of its context is known
+// beforehand, so an entry whose strings alone exceed max is never encoded,
+// and its size is estimated. Entries found oversized once encoded, because of
+// marshaled objects for instance, have their buffer dropped rather than put
+// back into the shared pool, where it would stay pinned.
+type maxEntryEncoder struct {
+	zapcore.Encoder
+	// base is the wrapped encoder as it was before any context was added.
+	base zapcore.Encoder
+	max  int
+	// context is the size of the strings and bytes of the context fields.
+	context int
+}
+
+func newMaxEntryEncoder(enc zapcore.Encoder, max int) zapcore.Encoder {
+	return &maxEntryEncoder{Encoder: enc, base: enc.Clone(), max: max}
+}
+
+func (enc *maxEntryEncoder) Clone() zapcore.Encoder {
+	return &maxEntryEncoder{Encoder: enc.Encoder.Clone(), base: enc.base, max: enc.max, context: enc.context}
+}
+
+func (enc *maxEntryEncoder) AddString(key, value string) {
+	enc.context += len(key) + len(value)
+	enc.Encoder.AddString(key, value)
+}
+
+func (enc *maxEntryEncoder) AddByteString(key string, value []byte) {
+	enc.context += len(key) + len(value)
+	enc.Encoder.AddByteString(key, value)
+}
+
+func (enc *maxEntryEncoder) AddBinary(key string, value []byte) {
+	// base64 encoded
+	enc.context += len(key) + (len(value)+2)/3*4
+	enc.Encoder.AddBinary(key, value)
+}
+
+func (enc *maxEntryEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	if size := enc.estimate(ent, fields); size > enc.max {
+		return enc.placeholder(ent, size)
+	}
+
+	buf, err := enc.Encoder.EncodeEntry(ent, fields)
+	if err != nil || buf.Len() <= enc.max {
+		return buf, err
+	}
+	// buf is not freed: an oversized buffer would stay in the pool.
+
+	return enc.placeholder(ent, buf.Len())
+}
+
+// estimate returns a lower bound of the encoded size of the entry, the size of
+// its strings and bytes.
+func (enc *maxEntryEncoder) estimate(ent zapcore.Entry, fields []zapcore.Field) int {
+	size := enc.context + len(ent.Message) + len(ent.Stack) + len(ent.LoggerName)
+	for _, f := range fields {
+		size += len(f.Key)
+		switch f.Type {
+		case zapcore.StringType:
+			size += len(f.String)
+		case zapcore.ByteStringType:
+			size += len(f.Interface.([]byte))
+		case zapcore.BinaryType:
+			size += (len(f.Interface.([]byte)) + 2) / 3 * 4
+		}
+	}
+
+	return size
+}
+
+func (enc *maxEntryEncoder) placeholder(ent zapcore.Entry, size int) (*buffer.Buffer, error) {
+	if len(ent.Message) > maxPlaceholderMessageBytes {
+		ent.Message = ent.Message[:maxPlaceholderMessageBytes]
+	}
+	ent.Stack = ""
+
+	return enc.base.EncodeEntry(ent, []zapcore.Field{
+		zap.Bool("entry_truncated", true),
+		zap.Int("entry_bytes", size),
+	})
+}
+
+// wrapEncoder wraps the messages of the console entries longer than width
+// characters, the continuation lines being indented. Lines are broken at the
+// last space fitting in width, or at width when there is none.
+type wrapEncoder struct {
+	zapcore.Encoder
+	width int
+}
+
+func (enc *wrapEncoder) Clone() zapcore.Encoder {
+	return &wrapEncoder{Encoder: enc.Encoder.Clone(), width: enc.width}
+}
+
+func (enc *wrapEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	ent.Message = wrapText(ent.Message, enc.width, multilineIndent)
+
+	return enc.Encoder.EncodeEntry(ent, fields)
+}
+
+// wrapText breaks the lines of s longer than width runes, prefixing the
+// continuation lines with indent. The continuation lines are at most width
+// runes long indent included.
+func wrapText(s string, width int, indent string) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	continued := max(width-utf8.RuneCountInString(indent), 1)
+
+	var b strings.Builder
+	for i, line := range strings.Split(s, "\n") {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		limit := width
+		for {
+			runes := []rune(line)
+			if len(runes) <= limit {
+				b.WriteString(line)
+
+				break
+			}
+			cut := limit
+			if space := strings.LastIndex(string(runes[:limit+1]), " "); space > 0 {
+				cut = utf8.RuneCountInString(line[:space])
+			}
+			b.WriteString(strings.TrimRight(string(runes[:cut]), " "))
+			b.WriteByte('\n')
+			b.WriteString(indent)
+			line = strings.TrimLeft(string(runes[cut:]), " ")
+			limit = continued
+		}
+	}
+
+	return b.String()
+}
+
+// separatorEncoder appends a separator to the entries at level or above.
+type separatorEncoder struct {
+	zapcore.Encoder
+	separator string
+	level     zapcore.Level
+}
+
+func (enc *separatorEncoder) Clone() zapcore.Encoder {
+	return &separatorEncoder{Encoder: enc.Encoder.Clone(), separator: enc.separator, level: enc.level}
+}
+
+func (enc *separatorEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	buf, err := enc.Encoder.EncodeEntry(ent, fields)
+	if err == nil && ent.Level >= enc.level {
+		buf.AppendString(enc.separator)
+	}
+
+	return buf, err
+}
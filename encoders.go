@@ -0,0 +1,53 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// encoderFactories holds the encoders registered with RegisterEncoder, by
+// lower case name.
+var encoderFactories = struct {
+	mu sync.RWMutex
+	m  map[string]func(zapcore.EncoderConfig) zapcore.Encoder
+}{m: map[string]func(zapcore.EncoderConfig) zapcore.Encoder{}}
+
+// RegisterEncoder registers a custom format, selected by setting Format or a
+// LevelFormats value to name, case insensitively. The factory is called with
+// the encoder configuration of the logger every time a logger is built.
+// Registering a name again replaces the previous factory. It panics if name is
+// empty or one of the built-in console, json and ecs formats.
+func RegisterEncoder(name string, factory func(zapcore.EncoderConfig) zapcore.Encoder) {
+	name = strings.ToLower(name)
+	switch name {
+	case "", consoleFormat, jsonFormat, ecsFormat:
+		panic(fmt.Sprintf("log: cannot register encoder %q", name))
+	}
+
+	encoderFactories.mu.Lock()
+	defer encoderFactories.mu.Unlock()
+	encoderFactories.m[name] = factory
+}
+
+// lookupEncoder returns the factory registered for format.
+func lookupEncoder(format string) (func(zapcore.EncoderConfig) zapcore.Encoder, bool) {
+	encoderFactories.mu.RLock()
+	defer encoderFactories.mu.RUnlock()
+	factory, ok := encoderFactories.m[strings.ToLower(format)]
+
+	return factory, ok
+}
+
+// validFormat reports whether format is a built-in or registered format.
+func validFormat(format string) bool {
+	switch format {
+	case consoleFormat, jsonFormat, ecsFormat:
+		return true
+	}
+	_, ok := lookupEncoder(format)
+
+	return ok
+}
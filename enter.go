@@ -0,0 +1,39 @@
+package log
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Enter logs entering the named function with the std logger and returns the
+// func logging its exit.
+func Enter(name string, fields ...Field) func() {
+	l := std()
+	start := l.opts.clock().Now()
+	l.zapLogger.Debug("entering "+name, fields...)
+
+	return func() {
+		l.zapLogger.Debug("exiting "+name, exitFields(fields, l.opts.clock().Now().Sub(start))...)
+	}
+}
+
+// Enter logs "entering <name>" at debug level and returns a func logging
+// "exiting <name>" with the elapsed time, to be deferred for tracing the flow
+// of a function:
+//
+//	defer logger.Enter("handleRequest", log.String("id", id))()
+//
+// Both entries have the given fields.
+func (l *zapLogger) Enter(name string, fields ...Field) func() {
+	start := l.opts.clock().Now()
+	l.zapLogger.Debug("entering "+name, fields...)
+
+	return func() {
+		l.zapLogger.Debug("exiting "+name, exitFields(fields, l.opts.clock().Now().Sub(start))...)
+	}
+}
+
+func exitFields(fields []Field, elapsed time.Duration) []Field {
+	return append(fields[:len(fields):len(fields)], zap.Duration("elapsed", elapsed))
+}
@@ -0,0 +1,56 @@
+package log
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// ErrorChain constructs a field with every error of the chain of err, from the
+// outermost to the innermost, as an array of {msg, type} objects. The branches
+// of joined errors, or of any error unwrapping to several ones, are all walked,
+// depth first.
+func ErrorChain(key string, err error) Field {
+	var chain errorChain
+	chain.walk(err)
+
+	return zap.Array(key, chain)
+}
+
+type errorChain []error
+
+func (c *errorChain) walk(err error) {
+	if err == nil {
+		return
+	}
+	*c = append(*c, err)
+
+	switch u := err.(type) {
+	case interface{ Unwrap() error }:
+		c.walk(u.Unwrap())
+	case interface{ Unwrap() []error }:
+		for _, err := range u.Unwrap() {
+			c.walk(err)
+		}
+	}
+}
+
+func (c errorChain) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, err := range c {
+		if err := enc.AppendObject(chainedError{err}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type chainedError struct{ err error }
+
+func (e chainedError) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("msg", e.err.Error())
+	enc.AddString("type", fmt.Sprintf("%T", e.err))
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package log
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const flagEvalMessage = "flag_eval"
+
+// FlagEval logs a feature flag evaluation with the std logger.
+func FlagEval(flag string, result bool, reason string) {
+	std().zapLogger.Log(std().opts.flagEvalLevel(), flagEvalMessage, flagEvalFields(flag, result, reason)...)
+}
+
+// FlagEval logs a feature flag evaluation as a "flag_eval" entry with flag,
+// result and reason fields, at the FlagEvalLevel, debug by default.
+func (l *zapLogger) FlagEval(flag string, result bool, reason string) {
+	l.zapLogger.Log(l.opts.flagEvalLevel(), flagEvalMessage, flagEvalFields(flag, result, reason)...)
+}
+
+func flagEvalFields(flag string, result bool, reason string) []zap.Field {
+	return []zap.Field{
+		zap.String("flag", flag),
+		zap.Bool("result", result),
+		zap.String("reason", reason),
+	}
+}
+
+// flagEvalLevel returns the level of the flag evaluation entries.
+func (o *Options) flagEvalLevel() zapcore.Level {
+	lvl, err := o.ParseLevel(o.FlagEvalLevel)
+	if err != nil || o.FlagEvalLevel == "" {
+		return zapcore.DebugLevel
+	}
+
+	return lvl
+}
@@ -0,0 +1,65 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// formatMismatchKey is the field describing why the format of a printf-style
+// call does not match its arguments, with FormatMismatchCheck.
+const formatMismatchKey = "format_mismatch"
+
+// formatEntry returns the message and fields of a printf-style call, unless it
+// needs no special treatment and can be handed over to the sugared logger.
+// With FormatMismatchCheck, a format not matching its arguments is written as
+// is, along with a format_mismatch field and the arguments, instead of the
+// %!-marked output of fmt.
+func (o *Options) formatEntry(format string, v []interface{}) (string, []zap.Field, bool) {
+	if o.FormatMismatchCheck {
+		if mismatch := checkFormat(format, len(v)); mismatch != "" {
+			return format, []zap.Field{zap.String(formatMismatchKey, mismatch), zap.Any(formatArgsKey, v)}, true
+		}
+	}
+	if len(v) == 0 {
+		return format, nil, true
+	}
+	if o.CaptureFormatArgs {
+		return fmt.Sprintf(format, v...), []zap.Field{zap.Any(formatArgsKey, v)}, true
+	}
+
+	return "", nil, false
+}
+
+// checkFormat describes how format does not match the number of arguments
+// given with it, or returns an empty string when it does. Formats using
+// explicit argument indexes are not checked.
+func checkFormat(format string, args int) string {
+	verbs := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		// Skip the flags, width and precision, a * taking an argument.
+		for i++; i < len(format) && strings.IndexByte("+-# 0123456789.*[", format[i]) >= 0; i++ {
+			switch format[i] {
+			case '[':
+				return ""
+			case '*':
+				verbs++
+			}
+		}
+		switch {
+		case i == len(format):
+			return "format ends with a lone %"
+		case format[i] != '%':
+			verbs++
+		}
+	}
+	if verbs != args {
+		return fmt.Sprintf("%d verbs for %d args", verbs, args)
+	}
+
+	return ""
+}
@@ -0,0 +1,24 @@
+package log
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const heartbeatMessage = "logger alive"
+
+// Heartbeat periodically logs a heartbeat with the std logger.
+func Heartbeat(interval time.Duration, level Level) func() { return std().Heartbeat(interval, level) }
+
+// Heartbeat logs a "logger alive" entry at the given level every interval and
+// flushes the logger, until the returned func is called. A gap in the
+// heartbeats tells the process died or its logging stalled.
+func (l *zapLogger) Heartbeat(interval time.Duration, level Level) func() {
+	logger := l.zapLogger.WithOptions(zap.WithCaller(false))
+
+	return pollEvery(l.opts.clock(), interval, func() {
+		logger.Log(level, heartbeatMessage)
+		_ = logger.Sync()
+	})
+}
@@ -0,0 +1,71 @@
+package log
+
+import (
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// noopHook is a zapcore.CheckWriteHook which does nothing after the entry is
+// written. zap refuses zapcore.WriteThenNoop for Panic and Fatal entries, so we
+// need our own implementation to keep the process running.
+type noopHook struct{}
+
+func (noopHook) OnWrite(*zapcore.CheckedEntry, []zapcore.Field) {}
+
+// panicValueHook is a zapcore.CheckWriteHook which panics with the value
+// returned by its function instead of the message of the entry.
+type panicValueHook func(msg string, fields []Field) interface{}
+
+func (h panicValueHook) OnWrite(ce *zapcore.CheckedEntry, fields []zapcore.Field) {
+	panic(h(ce.Message, fields))
+}
+
+// fatalHook is a zapcore.CheckWriteHook which gives the sinks up to grace to
+// flush before exiting, so that asynchronous or remote sinks can drain.
+type fatalHook struct {
+	grace time.Duration
+	sync  func() error
+	exit  bool
+}
+
+func (h *fatalHook) OnWrite(*zapcore.CheckedEntry, []zapcore.Field) {
+	done := make(chan struct{})
+	go func() {
+		_ = h.sync()
+		close(done)
+	}()
+
+	timer := time.NewTimer(h.grace)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+
+	if h.exit {
+		os.Exit(1)
+	}
+}
+
+// terminalHooks returns the zap options which control what happens after
+// Panic and Fatal level entries are written. syncFn flushes the built logger.
+func (o *Options) terminalHooks(syncFn func() error) []zap.Option {
+	var opts []zap.Option
+	switch {
+	case o.DisablePanic:
+		opts = append(opts, zap.WithPanicHook(noopHook{}))
+	case o.PanicValue != nil:
+		opts = append(opts, zap.WithPanicHook(panicValueHook(o.PanicValue)))
+	}
+	switch {
+	case o.FatalGracePeriod > 0:
+		opts = append(opts, zap.WithFatalHook(&fatalHook{grace: o.FatalGracePeriod, sync: syncFn, exit: !o.DisableFatalExit}))
+	case o.DisableFatalExit:
+		opts = append(opts, zap.WithFatalHook(noopHook{}))
+	}
+
+	return opts
+}
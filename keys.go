@@ -0,0 +1,51 @@
+package log
+
+import (
+	"strings"
+	"unicode"
+)
+
+// standardKeys are the keys of the entry itself, never transformed by
+// KeyTransform.
+var standardKeys = map[string]bool{
+	"timestamp":  true,
+	"level":      true,
+	"message":    true,
+	"logger":     true,
+	"caller":     true,
+	"stacktrace": true,
+}
+
+// transformKey applies the KeyTransform to the key of a key-value pair,
+// leaving the standard keys untouched.
+func (o *Options) transformKey(key string) string {
+	if o.KeyTransform == nil || standardKeys[key] {
+		return key
+	}
+
+	return o.KeyTransform(key)
+}
+
+// SnakeCase converts a camelCase or PascalCase key to snake_case, keeping
+// acronyms together: userID becomes user_id and HTTPServer http_server. It is
+// meant to be used as KeyTransform.
+func SnakeCase(key string) string {
+	runes := []rune(key)
+	var b strings.Builder
+	b.Grow(len(key) + 4)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && runes[i-1] != '_' {
+				prev := runes[i-1]
+				nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+				if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+					b.WriteByte('_')
+				}
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
@@ -6,6 +6,7 @@ import (
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"sync"
+	"sync/atomic"
 )
 
 // InfoLogger 表示记录非错误信息的能力，可以在特定的详细程度下输出日志。
@@ -67,6 +68,8 @@ type Logger interface {
 	// V 返回指定 verbosity level 的 InfoLogger。
 	// 数值越大表示日志越不重要。
 	// 传入的 level 不允许小于 0。
+	// Options.Verbosity 为 0 时 level 按日志级别处理，与之前的行为一致；
+	// 否则 level 大于 Options.Verbosity 时返回的 InfoLogger 不输出任何日志，否则以 info 级别输出。
 	V(level Level) InfoLogger
 
 	// Write 实现 io.Writer 接口，方便集成标准库或其他需要 writer 的组件
@@ -76,6 +79,14 @@ type Logger interface {
 	// 键值对会自动附加到后续所有日志中
 	WithValues(keysAndValues ...interface{}) Logger
 
+	// WithValuesBatch 一次性添加多组键值对到日志上下文中
+	// 效果等同于链式调用 WithValues，但只创建一个子日志器
+	WithValuesBatch(groups ...[]interface{}) Logger
+
+	// WithComponent 添加 component 字段到日志上下文中，派生的日志器同样带有该字段
+	// 用于在整个服务中统一标识组件，代替 WithValues("component", name)
+	WithComponent(name string) Logger
+
 	// WithName 添加一个名称元素到日志器中
 	// 多次调用 WithName 会追加名称片段
 	// 推荐使用字母、数字、短横线命名
@@ -87,10 +98,21 @@ type Logger interface {
 	// Flush 调用底层 Core 的 Sync 方法，将缓冲的日志条目刷新到磁盘或输出流
 	// 应用退出前应确保调用 Flush，避免丢失日志
 	Flush()
+
+	// Sync 与 Flush 相同，但返回刷新失败的错误，匹配 IgnoreSyncErrors 的错误会被忽略
+	Sync() error
+
+	// Go 在新的 goroutine 中执行 fn，并将当前日志器（包括其字段）传给 fn，
+	// fn 发生 panic 时会被 recover 并通过该日志器记录
+	Go(fn func(Logger))
 }
 
 var _ Logger = &zapLogger{}
 
+// formatArgsKey is the field printf-style methods put their arguments in
+// when CaptureFormatArgs is enabled.
+const formatArgsKey = "args"
+
 // infoLogger 是一个 logr.InfoLogger 的实现，使用 Zap 来记录日志
 type infoLogger struct {
 	level zapcore.Level // 对应的 Zap 日志级别
@@ -116,14 +138,14 @@ func (l *infoLogger) Infof(format string, args ...interface{}) {
 // Infow 支持 key/value 方式写日志
 func (l *infoLogger) Infow(msg string, keysAndValues ...interface{}) {
 	if checkedEntry := l.log.Check(l.level, msg); checkedEntry != nil {
-		checkedEntry.Write(handleFields(l.log, keysAndValues)...)
+		checkedEntry.Write(handleFields(l.log, nil, keysAndValues)...)
 	}
 }
 
 // handleFields converts a bunch of arbitrary key-value pairs into Zap fields.  It takes
 // additional pre-converted Zap fields, for use with automatically attached fields, like
-// `error`.
-func handleFields(l *zap.Logger, args []interface{}, additional ...zap.Field) []zap.Field {
+// `error`. The keys are passed through transform unless it is nil.
+func handleFields(l *zap.Logger, transform func(string) string, args []interface{}, additional ...zap.Field) []zap.Field {
 	// a slightly modified version of zap.SugaredLogger.sweetenFields
 	if len(args) == 0 {
 		// fast-return if we have no suggared fields.
@@ -164,6 +186,9 @@ func handleFields(l *zap.Logger, args []interface{}, additional ...zap.Field) []
 			break
 		}
 
+		if transform != nil {
+			keyStr = transform(keyStr)
+		}
 		fields = append(fields, zap.Any(keyStr, val))
 		i += 2
 	}
@@ -172,15 +197,51 @@ func handleFields(l *zap.Logger, args []interface{}, additional ...zap.Field) []
 }
 
 var (
-	std = New(NewOptions())
-	mu  sync.Mutex
+	stdLogger atomic.Pointer[zapLogger]
+	stdOnce   sync.Once
 )
 
-// Init initializes logger with specified options.
+// std returns the logger of the package level functions. Unless Init was
+// called first, it is built from the default options on first use, so that
+// the package level functions can be called at any time.
+func std() *zapLogger {
+	if l := stdLogger.Load(); l != nil {
+		return l
+	}
+	stdOnce.Do(func() {
+		stdLogger.CompareAndSwap(nil, newStd())
+	})
+
+	return stdLogger.Load()
+}
+
+// newStd builds the default std logger. Unlike New, it does not panic if the
+// default options fail to build, which would make any package level call
+// panic: it falls back to a console logger writing to stderr, and logs why.
+func newStd() *zapLogger {
+	opts := NewOptions()
+	logger, err := newLogger(opts)
+	if err == nil {
+		return logger
+	}
+
+	fallback := NewOptions()
+	fallback.Format = consoleFormat
+	fallback.OutputPaths = []string{"stderr"}
+	fallback.ErrorOutputPaths = []string{"stderr"}
+	logger = New(fallback)
+	logger.zapLogger.Warn("failed to build the default logger, logging to stderr", zap.Error(err))
+
+	return logger
+}
+
+// Init initializes logger with specified options. It can be called before
+// the first use of the package level functions, in which case the default
+// logger is never built, or later to replace it; calls running concurrently
+// use either the previous logger or the new one. Like New, it panics if opts
+// fail to build.
 func Init(opts *Options) {
-	mu.Lock()
-	defer mu.Unlock()
-	std = New(opts)
+	stdLogger.Store(New(opts))
 }
 
 // New create logger by opts which can custmoized by command arguments.
@@ -189,61 +250,46 @@ func New(opts *Options) *zapLogger {
 		opts = NewOptions()
 	}
 
-	var zapLevel zapcore.Level
-	if err := zapLevel.UnmarshalText([]byte(opts.Level)); err != nil {
-		zapLevel = zapcore.InfoLevel
-	}
-	encodeLevel := zapcore.CapitalLevelEncoder
-	// when output to local path, with color is forbidden
-	if opts.Format == consoleFormat {
-		encodeLevel = zapcore.CapitalColorLevelEncoder
-	}
-
-	encoderConfig := zapcore.EncoderConfig{
-		MessageKey:     "message",
-		LevelKey:       "level",
-		TimeKey:        "timestamp",
-		NameKey:        "logger",
-		CallerKey:      "caller",
-		StacktraceKey:  "stacktrace",
-		LineEnding:     zapcore.DefaultLineEnding,
-		EncodeLevel:    encodeLevel,
-		EncodeTime:     timeEncoder,
-		EncodeDuration: milliSecondsDurationEncoder,
-		EncodeCaller:   zapcore.ShortCallerEncoder,
-	}
-
-	loggerConfig := &zap.Config{
-		Level:             zap.NewAtomicLevelAt(zapLevel),
-		Development:       opts.Development,
-		DisableCaller:     opts.DisableCaller,
-		DisableStacktrace: opts.DisableStacktrace,
-		Sampling: &zap.SamplingConfig{
-			Initial:    100,
-			Thereafter: 100,
-		},
-		Encoding:         opts.Format,
-		EncoderConfig:    encoderConfig,
-		OutputPaths:      opts.OutputPaths,
-		ErrorOutputPaths: opts.ErrorOutputPaths,
+	logger, err := newLogger(opts)
+	if err != nil {
+		panic(err)
 	}
 
-	var err error
-	l, err := loggerConfig.Build(zap.AddStacktrace(zapcore.PanicLevel), zap.AddCallerSkip(1))
+	return logger
+}
+
+// newLogger creates a logger from opts, returning the error it failed to
+// build with, and redirects the standard library logger to it.
+func newLogger(opts *Options) (*zapLogger, error) {
+	logger, err := buildLogger(opts)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	// klog.InitLogger(l)
+	zap.RedirectStdLog(logger.infoLogger.log)
+
+	return logger, nil
+}
+
+// buildLogger creates a logger from opts, leaving the standard library
+// logger untouched.
+func buildLogger(opts *Options) (*zapLogger, error) {
+	l, state, err := opts.build(zap.AddCallerSkip(1))
+	if err != nil {
+		return nil, err
+	}
+	copied := *opts
 	logger := &zapLogger{
 		zapLogger: l.Named(opts.Name),
 		infoLogger: infoLogger{
 			log:   l,
 			level: zap.InfoLevel,
 		},
+		opts:  &copied,
+		state: state,
 	}
-	// klog.InitLogger(l)
-	zap.RedirectStdLog(l)
 
-	return logger
+	return logger, nil
 }
 
 // zapLogger is a logr.Logger that uses Zap to log.
@@ -252,14 +298,47 @@ type zapLogger struct {
 	// deals with our desire to have multiple verbosity levels.
 	zapLogger *zap.Logger
 	infoLogger
+	// opts are the options the logger was built from, shared with all the
+	// loggers derived from it and never modified after New.
+	opts *Options
+	// state is nil for loggers created by NewLogger, which wrap a zap logger
+	// built elsewhere.
+	state *loggerState
+	// requestSampled is set once WithContext added a request scoped sampler.
+	requestSampled bool
+	// traceVerbose is set once WithContext enabled every level for a sampled
+	// trace.
+	traceVerbose bool
+	// names and context are the names and fields added to the logger since
+	// it was built, applied again to the audit logger by Audit.
+	names   []string
+	context []zap.Field
 }
 
 // V return a leveled InfoLogger.
-func V(level Level) InfoLogger { return std.V(level) }
+func V(level Level) InfoLogger { return std().V(level) }
+
+// V returns an InfoLogger writing at info level if level is at most the
+// Verbosity of the options, and a disabled one doing nothing otherwise, which
+// makes a call such as V(5).Infof cost barely more than the comparison. Levels
+// below 0 are treated as 0.
+//
+// With a zero Verbosity, the default, level is the zap level the InfoLogger
+// writes at, as before Verbosity existed: V(1) writes at warn level.
 func (l *zapLogger) V(level Level) InfoLogger {
-	if l.zapLogger.Core().Enabled(level) {
+	if l.opts.Verbosity == 0 {
+		if l.zapLogger.Core().Enabled(level) {
+			return &infoLogger{
+				level: level,
+				log:   l.zapLogger,
+			}
+		}
+
+		return disabledInfoLogger
+	}
+	if int(level) <= l.opts.Verbosity && l.zapLogger.Core().Enabled(zapcore.InfoLevel) {
 		return &infoLogger{
-			level: level,
+			level: zapcore.InfoLevel,
 			log:   l.zapLogger,
 		}
 	}
@@ -274,48 +353,186 @@ func (l *zapLogger) Write(p []byte) (n int, err error) {
 }
 
 // WithValues creates a child logger and adds adds Zap fields to it.
-func WithValues(keysAndValues ...interface{}) Logger { return std.WithValues(keysAndValues...) }
+//
+// Fields are always written in the same order, in every format: first the
+// InitialFields sorted by key and the FieldsFromEnv ones, then the fields
+// added by WithValues, WithValuesBatch and WithComponent in the order they
+// were added, then the fields passed to the logging call, and last the fields
+// added by the options such as UptimeField.
+func WithValues(keysAndValues ...interface{}) Logger { return std().WithValues(keysAndValues...) }
 
 func (l *zapLogger) WithValues(keysAndValues ...interface{}) Logger {
-	newLogger := l.zapLogger.With(handleFields(l.zapLogger, keysAndValues)...)
+	return l.with(handleFields(l.zapLogger, l.opts.transformKey, keysAndValues)...)
+}
+
+// WithValuesBatch creates a child logger carrying all key-value groups at once.
+// It is equivalent to chaining WithValues for each group, but derives a single
+// logger instead of one per group.
+func WithValuesBatch(groups ...[]interface{}) Logger { return std().WithValuesBatch(groups...) }
+
+func (l *zapLogger) WithValuesBatch(groups ...[]interface{}) Logger {
+	var fields []zap.Field
+	for _, group := range groups {
+		fields = append(fields, handleFields(l.zapLogger, l.opts.transformKey, group)...)
+	}
+
+	return l.with(fields...)
+}
 
-	return NewLogger(newLogger)
+// componentKey is the key of the field added by WithComponent.
+const componentKey = "component"
+
+// WithComponent creates a child logger with a component field.
+func WithComponent(name string) Logger { return std().WithComponent(name) }
+
+// WithComponent creates a child logger with a component field set to name,
+// inherited by the loggers derived from it. It is the conventional way to tell
+// apart the components of a service, the logger name being left to WithName.
+func (l *zapLogger) WithComponent(name string) Logger {
+	return l.with(zap.String(componentKey, name))
 }
 
 // WithName adds a new path segment to the logger's name. Segments are joined by
 // periods. By default, Loggers are unnamed.
-func WithName(s string) Logger { return std.WithName(s) }
+func WithName(s string) Logger { return std().WithName(s) }
 
 func (l *zapLogger) WithName(name string) Logger {
-	newLogger := l.zapLogger.Named(name)
+	lg := l.derive(l.zapLogger.Named(name))
+	lg.names = append(l.names[:len(l.names):len(l.names)], name)
 
-	return NewLogger(newLogger)
+	return lg
 }
 
 // Flush calls the underlying Core's Sync method, flushing any buffered
 // log entries. Applications should take care to call Sync before exiting.
-func Flush() { std.Flush() }
+func Flush() { std().Flush() }
 
 func (l *zapLogger) Flush() {
 	_ = l.zapLogger.Sync()
 }
 
+// Pause stops the std logger from writing any entry until Resume is called.
+func Pause() { std().Pause() }
+
+// Pause drops every entry written to the logger, or to any logger sharing its
+// core, until Resume is called. The configuration is left untouched, so
+// resuming restores exactly the previous output. Panic and Fatal still panic
+// and exit while paused.
+func (l *zapLogger) Pause() {
+	if l.state != nil {
+		l.state.paused.Store(true)
+	}
+}
+
+// Resume restarts the std logger after Pause.
+func Resume() { std().Resume() }
+
+// Resume restarts writing the entries after Pause.
+func (l *zapLogger) Resume() {
+	if l.state != nil {
+		l.state.paused.Store(false)
+	}
+}
+
+// Close flushes the std logger and releases its resources.
+func Close() { std().Close() }
+
+// Close flushes the logger and releases the resources held by its sinks, such
+// as output buffers and files. Since the sinks are shared, closing a logger
+// closes all the loggers derived from it as well, none of them may be used
+// afterwards. Closing a logger twice is a no-op.
+//
+// When a shutdown message is configured, it is logged once before flushing.
+func (l *zapLogger) Close() {
+	if l.state == nil {
+		l.Flush()
+
+		return
+	}
+
+	l.state.closeOnce.Do(func() {
+		if msg := l.opts.ShutdownMessage; msg != "" {
+			l.logShutdown(msg)
+		}
+		l.Flush()
+		l.state.closeFn()
+	})
+}
+
+func (l *zapLogger) logShutdown(msg string) {
+	var written, sampledOut uint64
+	stats := l.state.stats.snapshot()
+	for _, n := range stats.Written {
+		written += n
+	}
+	for _, n := range stats.SampledOut {
+		sampledOut += n
+	}
+
+	l.zapLogger.Info(msg,
+		zap.Duration("uptime", l.opts.clock().Now().Sub(l.state.start)),
+		zap.Uint64("written", written),
+		zap.Uint64("sampledOut", sampledOut),
+	)
+}
+
 var _ Logger = &zapLogger{}
 
 // NewLogger creates a new logr.Logger using the given Zap Logger to log.
+// A nil l gives a logger built from the default options, as New(nil) does but
+// without redirecting the standard library logger.
 func NewLogger(l *zap.Logger) Logger {
+	if l == nil {
+		opts := NewOptions()
+		l, state, err := opts.build(zap.AddCallerSkip(1))
+		if err != nil {
+			l, state = zap.NewNop(), nil
+		}
+
+		return &zapLogger{
+			zapLogger: l,
+			infoLogger: infoLogger{
+				log:   l,
+				level: zap.InfoLevel,
+			},
+			opts:  opts,
+			state: state,
+		}
+	}
+
 	return &zapLogger{
 		zapLogger: l,
 		infoLogger: infoLogger{
 			log:   l,
 			level: zap.InfoLevel,
 		},
+		opts: NewOptions(),
 	}
 }
 
+// derive returns a copy of the logger which writes to the given zap logger.
+func (l *zapLogger) derive(zl *zap.Logger) *zapLogger {
+	lg := l.clone()
+	lg.zapLogger = zl
+	lg.infoLogger = infoLogger{
+		log:   zl,
+		level: zap.InfoLevel,
+	}
+
+	return lg
+}
+
+// with derives a logger adding the fields to its context.
+func (l *zapLogger) with(fields ...zap.Field) *zapLogger {
+	lg := l.derive(l.zapLogger.With(fields...))
+	lg.context = append(l.context[:len(l.context):len(l.context)], fields...)
+
+	return lg
+}
+
 // ZapLogger used for other log wrapper such as klog.
 func ZapLogger() *zap.Logger {
-	return std.zapLogger
+	return std().zapLogger
 }
 
 // CheckIntLevel used for other log wrapper such as klog which return if logging a
@@ -327,14 +544,14 @@ func CheckIntLevel(level int32) bool {
 	} else {
 		lvl = zapcore.DebugLevel
 	}
-	checkEntry := std.zapLogger.Check(lvl, "")
+	checkEntry := std().zapLogger.Check(lvl, "")
 
 	return checkEntry != nil
 }
 
 // Debug method output debug level log.
 func Debug(msg string, fields ...Field) {
-	std.zapLogger.Debug(msg, fields...)
+	std().zapLogger.Debug(msg, fields...)
 }
 
 func (l *zapLogger) Debug(msg string, fields ...Field) {
@@ -343,16 +560,26 @@ func (l *zapLogger) Debug(msg string, fields ...Field) {
 
 // Debugf method output debug level log.
 func Debugf(format string, v ...interface{}) {
-	std.zapLogger.Sugar().Debugf(format, v...)
+	if msg, fields, ok := std().opts.formatEntry(format, v); ok {
+		std().zapLogger.Debug(msg, fields...)
+
+		return
+	}
+	std().zapLogger.Sugar().Debugf(format, v...)
 }
 
 func (l *zapLogger) Debugf(format string, v ...interface{}) {
+	if msg, fields, ok := l.opts.formatEntry(format, v); ok {
+		l.zapLogger.Debug(msg, fields...)
+
+		return
+	}
 	l.zapLogger.Sugar().Debugf(format, v...)
 }
 
 // Debugw method output debug level log.
 func Debugw(msg string, keysAndValues ...interface{}) {
-	std.zapLogger.Sugar().Debugw(msg, keysAndValues...)
+	std().zapLogger.Sugar().Debugw(msg, keysAndValues...)
 }
 
 func (l *zapLogger) Debugw(msg string, keysAndValues ...interface{}) {
@@ -361,7 +588,7 @@ func (l *zapLogger) Debugw(msg string, keysAndValues ...interface{}) {
 
 // Info method output info level log.
 func Info(msg string, fields ...Field) {
-	std.zapLogger.Info(msg, fields...)
+	std().zapLogger.Info(msg, fields...)
 }
 
 func (l *zapLogger) Info(msg string, fields ...Field) {
@@ -370,16 +597,26 @@ func (l *zapLogger) Info(msg string, fields ...Field) {
 
 // Infof method output info level log.
 func Infof(format string, v ...interface{}) {
-	std.zapLogger.Sugar().Infof(format, v...)
+	if msg, fields, ok := std().opts.formatEntry(format, v); ok {
+		std().zapLogger.Info(msg, fields...)
+
+		return
+	}
+	std().zapLogger.Sugar().Infof(format, v...)
 }
 
 func (l *zapLogger) Infof(format string, v ...interface{}) {
+	if msg, fields, ok := l.opts.formatEntry(format, v); ok {
+		l.zapLogger.Info(msg, fields...)
+
+		return
+	}
 	l.zapLogger.Sugar().Infof(format, v...)
 }
 
 // Infow method output info level log.
 func Infow(msg string, keysAndValues ...interface{}) {
-	std.zapLogger.Sugar().Infow(msg, keysAndValues...)
+	std().zapLogger.Sugar().Infow(msg, keysAndValues...)
 }
 
 func (l *zapLogger) Infow(msg string, keysAndValues ...interface{}) {
@@ -388,7 +625,7 @@ func (l *zapLogger) Infow(msg string, keysAndValues ...interface{}) {
 
 // Warn method output warning level log.
 func Warn(msg string, fields ...Field) {
-	std.zapLogger.Warn(msg, fields...)
+	std().zapLogger.Warn(msg, fields...)
 }
 
 func (l *zapLogger) Warn(msg string, fields ...Field) {
@@ -397,16 +634,26 @@ func (l *zapLogger) Warn(msg string, fields ...Field) {
 
 // Warnf method output warning level log.
 func Warnf(format string, v ...interface{}) {
-	std.zapLogger.Sugar().Warnf(format, v...)
+	if msg, fields, ok := std().opts.formatEntry(format, v); ok {
+		std().zapLogger.Warn(msg, fields...)
+
+		return
+	}
+	std().zapLogger.Sugar().Warnf(format, v...)
 }
 
 func (l *zapLogger) Warnf(format string, v ...interface{}) {
+	if msg, fields, ok := l.opts.formatEntry(format, v); ok {
+		l.zapLogger.Warn(msg, fields...)
+
+		return
+	}
 	l.zapLogger.Sugar().Warnf(format, v...)
 }
 
 // Warnw method output warning level log.
 func Warnw(msg string, keysAndValues ...interface{}) {
-	std.zapLogger.Sugar().Warnw(msg, keysAndValues...)
+	std().zapLogger.Sugar().Warnw(msg, keysAndValues...)
 }
 
 func (l *zapLogger) Warnw(msg string, keysAndValues ...interface{}) {
@@ -415,7 +662,7 @@ func (l *zapLogger) Warnw(msg string, keysAndValues ...interface{}) {
 
 // Error method output error level log.
 func Error(msg string, fields ...Field) {
-	std.zapLogger.Error(msg, fields...)
+	std().zapLogger.Error(msg, fields...)
 }
 
 func (l *zapLogger) Error(msg string, fields ...Field) {
@@ -424,16 +671,26 @@ func (l *zapLogger) Error(msg string, fields ...Field) {
 
 // Errorf method output error level log.
 func Errorf(format string, v ...interface{}) {
-	std.zapLogger.Sugar().Errorf(format, v...)
+	if msg, fields, ok := std().opts.formatEntry(format, v); ok {
+		std().zapLogger.Error(msg, fields...)
+
+		return
+	}
+	std().zapLogger.Sugar().Errorf(format, v...)
 }
 
 func (l *zapLogger) Errorf(format string, v ...interface{}) {
+	if msg, fields, ok := l.opts.formatEntry(format, v); ok {
+		l.zapLogger.Error(msg, fields...)
+
+		return
+	}
 	l.zapLogger.Sugar().Errorf(format, v...)
 }
 
 // Errorw method output error level log.
 func Errorw(msg string, keysAndValues ...interface{}) {
-	std.zapLogger.Sugar().Errorw(msg, keysAndValues...)
+	std().zapLogger.Sugar().Errorw(msg, keysAndValues...)
 }
 
 func (l *zapLogger) Errorw(msg string, keysAndValues ...interface{}) {
@@ -442,7 +699,7 @@ func (l *zapLogger) Errorw(msg string, keysAndValues ...interface{}) {
 
 // Panic method output panic level log and shutdown application.
 func Panic(msg string, fields ...Field) {
-	std.zapLogger.Panic(msg, fields...)
+	std().zapLogger.Panic(msg, fields...)
 }
 
 func (l *zapLogger) Panic(msg string, fields ...Field) {
@@ -451,16 +708,26 @@ func (l *zapLogger) Panic(msg string, fields ...Field) {
 
 // Panicf method output panic level log and shutdown application.
 func Panicf(format string, v ...interface{}) {
-	std.zapLogger.Sugar().Panicf(format, v...)
+	if msg, fields, ok := std().opts.formatEntry(format, v); ok {
+		std().zapLogger.Panic(msg, fields...)
+
+		return
+	}
+	std().zapLogger.Sugar().Panicf(format, v...)
 }
 
 func (l *zapLogger) Panicf(format string, v ...interface{}) {
+	if msg, fields, ok := l.opts.formatEntry(format, v); ok {
+		l.zapLogger.Panic(msg, fields...)
+
+		return
+	}
 	l.zapLogger.Sugar().Panicf(format, v...)
 }
 
 // Panicw method output panic level log.
 func Panicw(msg string, keysAndValues ...interface{}) {
-	std.zapLogger.Sugar().Panicw(msg, keysAndValues...)
+	std().zapLogger.Sugar().Panicw(msg, keysAndValues...)
 }
 
 func (l *zapLogger) Panicw(msg string, keysAndValues ...interface{}) {
@@ -469,7 +736,7 @@ func (l *zapLogger) Panicw(msg string, keysAndValues ...interface{}) {
 
 // Fatal method output fatal level log.
 func Fatal(msg string, fields ...Field) {
-	std.zapLogger.Fatal(msg, fields...)
+	std().zapLogger.Fatal(msg, fields...)
 }
 
 func (l *zapLogger) Fatal(msg string, fields ...Field) {
@@ -478,36 +745,64 @@ func (l *zapLogger) Fatal(msg string, fields ...Field) {
 
 // Fatalf method output fatal level log.
 func Fatalf(format string, v ...interface{}) {
-	std.zapLogger.Sugar().Fatalf(format, v...)
+	if msg, fields, ok := std().opts.formatEntry(format, v); ok {
+		std().zapLogger.Fatal(msg, fields...)
+
+		return
+	}
+	std().zapLogger.Sugar().Fatalf(format, v...)
 }
 
 func (l *zapLogger) Fatalf(format string, v ...interface{}) {
+	if msg, fields, ok := l.opts.formatEntry(format, v); ok {
+		l.zapLogger.Fatal(msg, fields...)
+
+		return
+	}
 	l.zapLogger.Sugar().Fatalf(format, v...)
 }
 
 // Fatalw method output Fatalw level log.
 func Fatalw(msg string, keysAndValues ...interface{}) {
-	std.zapLogger.Sugar().Fatalw(msg, keysAndValues...)
+	std().zapLogger.Sugar().Fatalw(msg, keysAndValues...)
 }
 
 func (l *zapLogger) Fatalw(msg string, keysAndValues ...interface{}) {
 	l.zapLogger.Sugar().Fatalw(msg, keysAndValues...)
 }
 
+// Go runs fn in a new goroutine with the std logger.
+func Go(fn func(Logger)) { std().Go(fn) }
+
+// Go runs fn in a new goroutine, handing it the logger so that the logs of
+// the goroutine carry the same fields, such as the request ID. A panic in fn
+// is recovered and logged at error level with its stack.
+func (l *zapLogger) Go(fn func(Logger)) {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				l.zapLogger.Error("recovered from panic in goroutine", zap.Any("panic", r), zap.Stack("stack"))
+			}
+		}()
+
+		fn(l)
+	}()
+}
+
 // L method output with specified context value.
 func L(ctx context.Context) *zapLogger {
-	return std.L(ctx)
+	return std().L(ctx)
 }
 
 func (l *zapLogger) L(ctx context.Context) *zapLogger {
 	lg := l.clone()
 
 	if requestID := ctx.Value(KeyRequestID); requestID != nil {
-		lg.zapLogger = lg.zapLogger.With(zap.Any(KeyRequestID, requestID))
+		lg = lg.with(zap.Any(KeyRequestID, requestID))
 	}
 
 	if watcherName := ctx.Value(KeyWatcherName); watcherName != nil {
-		lg.zapLogger = lg.zapLogger.With(zap.Any(KeyWatcherName, watcherName))
+		lg = lg.with(zap.Any(KeyWatcherName, watcherName))
 	}
 
 	return lg
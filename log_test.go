@@ -1,11 +1,32 @@
 package log_test
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/lwm-galactic/log"
+	"io"
+	stdlog "log"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 func Test_WithName(t *testing.T) {
@@ -23,6 +44,155 @@ func Test_WithValues(t *testing.T) {
 	logger.Info("Hello world!")
 }
 
+func Test_WithComponent(t *testing.T) {
+	opts, path := newTestOptions(t)
+	logger := log.New(opts).WithComponent("billing")
+
+	logger.Info("from component")
+	logger.WithValues("key", "value").WithName("worker").Info("from derived")
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "billing", entries[0]["component"])
+	assert.Equal(t, "billing", entries[1]["component"])
+	assert.Equal(t, "worker", entries[1]["logger"])
+}
+
+func Test_FieldOrder(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.Format = "console"
+	opts.DisableCaller = true
+	opts.UptimeField = true
+	opts.InitialFields = map[string]interface{}{"service": "api", "region": "eu"}
+	logger := log.New(opts).WithValues("b", 1).WithComponent("billing").WithValues("a", 2)
+
+	logger.Info("ordered", log.Int("z", 3), log.Int("c", 4))
+	logger.Flush()
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	parts := strings.Split(strings.TrimSpace(string(data)), "\t")
+	assert.Equal(t, "ordered", parts[2])
+
+	dec := json.NewDecoder(strings.NewReader(parts[3]))
+	var keys []string
+	_, err = dec.Token()
+	assert.Nil(t, err)
+	for dec.More() {
+		key, err := dec.Token()
+		assert.Nil(t, err)
+		keys = append(keys, key.(string))
+		var value interface{}
+		assert.Nil(t, dec.Decode(&value))
+	}
+	assert.Equal(t, []string{"region", "service", "b", "component", "a", "z", "c", "uptime_ms"}, keys)
+}
+
+func Test_WithValuesBatch(t *testing.T) {
+	opts, path := newTestOptions(t)
+	logger := log.New(opts)
+
+	logger.WithValuesBatch(
+		[]interface{}{"request_id", "r-1"},
+		[]interface{}{"user", "alice", "method", "GET"},
+	).Info("batched")
+	logger.WithValues("request_id", "r-1").WithValues("user", "alice", "method", "GET").Info("chained")
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	for _, entry := range entries {
+		assert.Equal(t, "r-1", entry["request_id"])
+		assert.Equal(t, "alice", entry["user"])
+		assert.Equal(t, "GET", entry["method"])
+	}
+}
+
+func Test_ConsoleMultiline(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.Format = "console"
+	opts.ConsoleMultiline = true
+	opts.DisableCaller = true
+	logger := log.New(opts).WithValues("request_id", "r-1")
+
+	logger.Info("request done", log.Int("status", 200), log.String("path", "/users"),
+		log.Any("tags", []string{"a", "b"}))
+	logger.Flush()
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	assert.Len(t, lines, 5)
+	assert.True(t, strings.HasSuffix(lines[0], "\trequest done"))
+	assert.Equal(t, []string{
+		"    request_id: r-1",
+		"    status: 200",
+		"    path: /users",
+		`    tags: ["a","b"]`,
+	}, lines[1:])
+}
+
+func Test_SampledMessages(t *testing.T) {
+	opts, path := newTestOptions(t)
+	clock := newFakeClock()
+	opts.Clock = clock
+	opts.SampledMessages = map[string]log.SampleRate{
+		"cache miss": {First: 2, Thereafter: 5},
+	}
+	logger := log.New(opts)
+
+	for i := 0; i < 12; i++ {
+		logger.Info("cache miss")
+		logger.Info("request done", log.Int("i", i))
+	}
+	clock.Advance(time.Second)
+	logger.Info("cache miss")
+	logger.Flush()
+
+	counts := map[string]int{}
+	for _, entry := range readEntries(t, path) {
+		counts[entry["message"].(string)]++
+	}
+	// 2 first, then the 7th and 12th, then the first of the next tick.
+	assert.Equal(t, 5, counts["cache miss"])
+	assert.Equal(t, 12, counts["request done"])
+	assert.Equal(t, uint64(8), logger.Stats().SampledOut["info"])
+}
+
+func Test_EntrySeparator(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.Format = "console"
+	opts.DisableCaller = true
+	opts.EntrySeparator = "----\n"
+	opts.EntrySeparatorLevel = "warn"
+	logger := log.New(opts)
+
+	logger.Info("first")
+	logger.Warn("second")
+	logger.Error("third")
+	logger.Info("fourth")
+	logger.Flush()
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if line == "----" {
+			lines = append(lines, line)
+		} else {
+			lines = append(lines, line[strings.LastIndexByte(line, '\t')+1:])
+		}
+	}
+	assert.Equal(t, []string{"first", "second", "----", "third", "----", "fourth"}, lines)
+
+	// json logs are never separated.
+	opts, path = newTestOptions(t)
+	opts.EntrySeparator = "----\n"
+	log.New(opts).Error("json")
+	assert.Len(t, readEntries(t, path), 1)
+}
+
 func Test_V(t *testing.T) {
 	defer log.Flush() // used for record logger printer
 
@@ -35,9 +205,2662 @@ func Test_Option(t *testing.T) {
 	opt := log.NewOptions()
 	opt.AddFlags(fs)
 
-	args := []string{"--log.level=debug"}
+	args := []string{
+		"--log.level=debug",
+		"--log.format=json",
+		"--log.output-paths=stdout,/var/log/app.log",
+		"--log.disable-caller",
+		"--log.verbosity=2",
+	}
 	err := fs.Parse(args)
 	assert.Nil(t, err)
 
 	assert.Equal(t, "debug", opt.Level)
+	lvl, err := opt.ParseLevel(opt.Level)
+	assert.Nil(t, err)
+	assert.Equal(t, log.DebugLevel, lvl)
+	assert.Equal(t, "json", opt.Format)
+	assert.Equal(t, []string{"stdout", "/var/log/app.log"}, opt.OutputPaths)
+	assert.True(t, opt.DisableCaller)
+	assert.Equal(t, 2, opt.Verbosity)
+	assert.Empty(t, opt.Validate())
+
+	for _, name := range []string{"log.level", "log.format", "log.output-paths", "log.error-output-paths", "log.development", "log.name"} {
+		assert.NotNil(t, fs.Lookup(name), name)
+	}
+}
+
+func Test_LevelColors(t *testing.T) {
+	opts, path := newTestOptions(t)
+	config := `{"format": "console", "level-colors": {"info": "green", "error": "Cyan"}}`
+	assert.Nil(t, json.Unmarshal([]byte(config), opts))
+	assert.Empty(t, opts.Validate())
+	logger := log.New(opts)
+
+	logger.Info("info message")
+	logger.Warn("warn message")
+	logger.Error("error message")
+	logger.Flush()
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), "\x1b[32mINFO\x1b[0m")
+	assert.Contains(t, string(data), "\x1b[33mWARN\x1b[0m") // default color
+	assert.Contains(t, string(data), "\x1b[36mERROR\x1b[0m")
+
+	opts.LevelColors = map[string]string{"loud": "red", "warn": "orange"}
+	assert.Len(t, opts.Validate(), 2)
+}
+
+func Test_DuplicateOutputPaths(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.OutputPaths = []string{path, filepath.Dir(path) + "/./" + filepath.Base(path), path}
+	logger := log.New(opts)
+
+	logger.Info("written once")
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "duplicate output paths ignored", entries[0]["message"])
+	assert.Len(t, entries[0]["paths"], 2)
+	assert.Equal(t, "written once", entries[1]["message"])
+}
+
+func Test_AllowNoOutput(t *testing.T) {
+	opts := log.NewOptions()
+	opts.OutputPaths = nil
+	assert.NotEmpty(t, opts.Validate())
+	assert.NotNil(t, opts.Build())
+	assert.Panics(t, func() { log.New(opts) })
+
+	opts.AllowNoOutput = true
+	assert.Empty(t, opts.Validate())
+	logger := log.New(opts)
+	assert.NotPanics(t, func() {
+		logger.Info("discarded")
+		logger.Flush()
+	})
+}
+
+func Test_NewLoggerNil(t *testing.T) {
+	logger := log.NewLogger(nil)
+
+	assert.NotPanics(t, func() {
+		logger.Info("logged with the default options")
+		logger.WithValues("key", "value").Infof("formatted %d", 1)
+		logger.V(0).Info("verbose")
+		logger.Flush()
+	})
+}
+
+func Test_DisableFatalExit(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.DisableFatalExit = true
+	opts.DisablePanic = true
+	logger := log.New(opts)
+
+	logger.Fatal("fatal message")
+	assert.NotPanics(t, func() { logger.Panicf("panic %s", "message") })
+	logger.Flush()
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), "fatal message")
+	assert.Contains(t, string(data), "panic message")
+}
+
+func Test_PanicDiagnostics(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.PanicDiagnostics = true
+	opts.DisableFatalExit = true
+	logger := log.New(opts)
+
+	logger.Error("error message")
+	assert.Panics(t, func() { logger.Panic("panic message") })
+	logger.Fatal("fatal message")
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 3)
+	assert.NotContains(t, entries[0], "goroutines")
+
+	assert.Greater(t, entries[1]["goroutines"], float64(0))
+	memstats, ok := entries[1]["memstats"].(map[string]interface{})
+	assert.True(t, ok)
+	assert.Greater(t, memstats["heap_alloc"], float64(0))
+	assert.NotContains(t, entries[1], "goroutine_dump")
+
+	assert.Contains(t, entries[2]["goroutine_dump"], "goroutine ")
+}
+
+func Test_UptimeField(t *testing.T) {
+	clock := newFakeClock()
+	opts, path := newTestOptions(t)
+	opts.Clock = clock
+	opts.UptimeField = true
+	logger := log.New(opts)
+
+	clock.Advance(5 * time.Millisecond)
+	logger.Info("first")
+	clock.Advance(1500 * time.Millisecond)
+	logger.Info("second")
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, float64(5), entries[0]["uptime_ms"])
+	assert.Equal(t, float64(1505), entries[1]["uptime_ms"])
+}
+
+func Test_JSONSeq(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.JSONSeq = true
+	logger := log.New(opts)
+
+	logger.Info("first")
+	logger.Info("second")
+	logger.Flush()
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	records := strings.Split(string(data), "\x1e")
+	assert.Len(t, records, 3)
+	assert.Empty(t, records[0])
+	for _, record := range records[1:] {
+		assert.True(t, strings.HasSuffix(record, "\n"))
+		entry := map[string]interface{}{}
+		assert.Nil(t, json.Unmarshal([]byte(record), &entry))
+	}
+
+	opts.Format = "console"
+	assert.NotEmpty(t, opts.Validate())
+}
+
+func Test_NumericLevelKey(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.Level = "debug"
+	opts.NumericLevelKey = "severity"
+	logger := log.New(opts)
+
+	logger.Debug("debug message")
+	logger.Info("info message")
+	logger.Error("error message")
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, "DEBUG", entries[0]["level"])
+	assert.Equal(t, float64(-1), entries[0]["severity"])
+	assert.Equal(t, "INFO", entries[1]["level"])
+	assert.Equal(t, float64(0), entries[1]["severity"])
+	assert.Equal(t, "ERROR", entries[2]["level"])
+	assert.Equal(t, float64(2), entries[2]["severity"])
+}
+
+type panicError struct {
+	msg    string
+	fields int
+}
+
+func (e *panicError) Error() string { return e.msg }
+
+func Test_PanicValue(t *testing.T) {
+	opts, _ := newTestOptions(t)
+	opts.PanicValue = func(msg string, fields []log.Field) interface{} {
+		return &panicError{msg: msg, fields: len(fields)}
+	}
+	logger := log.New(opts)
+
+	defer func() {
+		err, ok := recover().(*panicError)
+		assert.True(t, ok)
+		assert.Equal(t, "panic message", err.msg)
+		assert.Equal(t, 1, err.fields)
+	}()
+	logger.Panic("panic message", log.String("key", "value"))
+}
+
+// testSink is a zap.Sink whose behavior is controlled by the test, it is
+// opened with the "test://<name>" output path.
+type testSink struct {
+	mu         sync.Mutex
+	buf        bytes.Buffer
+	writeDelay time.Duration
+	syncDelay  time.Duration
+	synced     bool
+	// failures is the number of writes failing before the following succeed.
+	failures int
+	// syncErr is returned by every Sync.
+	syncErr error
+}
+
+var (
+	testSinks     sync.Map
+	testSinkCount atomic.Int64
+)
+
+func init() {
+	_ = zap.RegisterSink("test", func(u *url.URL) (zap.Sink, error) {
+		sink, ok := testSinks.Load(u.Host)
+		if !ok {
+			return nil, fmt.Errorf("unknown test sink %q", u.Host)
+		}
+
+		return sink.(*testSink), nil
+	})
+}
+
+// newTestSink registers a sink for the test and returns its output path.
+func newTestSink(t *testing.T) (*testSink, string) {
+	t.Helper()
+
+	name := fmt.Sprintf("%s-%d", strings.ToLower(strings.NewReplacer("/", "-", "_", "-").Replace(t.Name())), testSinkCount.Add(1))
+	sink := &testSink{}
+	testSinks.Store(name, sink)
+	t.Cleanup(func() { testSinks.Delete(name) })
+
+	return sink, "test://" + name
+}
+
+func (s *testSink) Write(p []byte) (int, error) {
+	time.Sleep(s.writeDelay)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+
+		return 0, errors.New("transient failure")
+	}
+
+	return s.buf.Write(p)
+}
+
+func (s *testSink) Sync() error {
+	time.Sleep(s.syncDelay)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.synced = true
+
+	return s.syncErr
+}
+
+func (s *testSink) Close() error { return nil }
+
+func (s *testSink) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.buf.String()
+}
+
+func (s *testSink) Synced() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.synced
+}
+
+// newTestOptions returns options writing json logs into a temporary file.
+func newTestOptions(t *testing.T) (*log.Options, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "test.log")
+	opts := log.NewOptions()
+	opts.Format = "json"
+	opts.OutputPaths = []string{path}
+
+	return opts, path
+}
+
+// readEntries decodes every json log line from the file at path.
+func readEntries(t *testing.T, path string) []map[string]interface{} {
+	t.Helper()
+
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	var entries []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := map[string]interface{}{}
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func Test_SuppressRepeatedContext(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.SuppressRepeatedContext = true
+	logger := log.New(opts)
+
+	for i := 0; i < 102; i++ {
+		logger.Info(fmt.Sprintf("message %d", i), log.String("user", "Alice"))
+	}
+	logger.Info("other", log.String("user", "Bob"))
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 103)
+	assert.Equal(t, "Alice", entries[0]["user"])
+	for _, entry := range entries[1:101] {
+		assert.Equal(t, "(same context)", entry["context"])
+		assert.Nil(t, entry["user"])
+	}
+	assert.Equal(t, "Alice", entries[101]["user"])
+	assert.Equal(t, "Bob", entries[102]["user"])
+}
+
+func Test_SlogHandler(t *testing.T) {
+	opts, path := newTestOptions(t)
+	logger := slog.New(log.New(opts).SlogHandler())
+
+	logger.Info("standard level", "user", "Alice")
+	logger.Log(context.Background(), slog.LevelInfo+2, "custom level")
+	logger.Log(context.Background(), slog.LevelInfo+3, "nearer to warn")
+	logger.Log(context.Background(), slog.LevelError+4, "above error")
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 4)
+	assert.Equal(t, "INFO", entries[0]["level"])
+	assert.Equal(t, "Alice", entries[0]["user"])
+	assert.Nil(t, entries[0]["slog_level"])
+	assert.Equal(t, "INFO", entries[1]["level"])
+	assert.Equal(t, float64(slog.LevelInfo+2), entries[1]["slog_level"])
+	assert.Equal(t, "WARN", entries[2]["level"])
+	assert.Equal(t, float64(slog.LevelInfo+3), entries[2]["slog_level"])
+	assert.Equal(t, "ERROR", entries[3]["level"])
+	assert.Equal(t, float64(slog.LevelError+4), entries[3]["slog_level"])
+}
+
+func Test_SplitStdStreams(t *testing.T) {
+	dir := t.TempDir()
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	assert.Nil(t, err)
+	stderr, err := os.Create(filepath.Join(dir, "stderr"))
+	assert.Nil(t, err)
+
+	origStdout, origStderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = stdout, stderr
+	defer func() { os.Stdout, os.Stderr = origStdout, origStderr }()
+
+	opts := log.NewOptions()
+	opts.SplitStdStreams = true
+	logger := log.New(opts)
+	logger.Info("info message")
+	logger.Error("error message")
+	logger.Flush()
+
+	out, err := os.ReadFile(stdout.Name())
+	assert.Nil(t, err)
+	errOut, err := os.ReadFile(stderr.Name())
+	assert.Nil(t, err)
+	assert.Contains(t, string(out), "info message")
+	assert.NotContains(t, string(out), "error message")
+	assert.Contains(t, string(errOut), "error message")
+	assert.NotContains(t, string(errOut), "info message")
+}
+
+func Test_BufferSize(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.BufferSize = 64 * 1024
+	logger := log.New(opts)
+	defer logger.Close()
+
+	logger.WithValues("key", "value").Info("from values child")
+	logger.WithName("child").Info("from named child")
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Empty(t, data)
+
+	// the children write into the parent's buffer, so flushing the parent
+	// is enough to get their entries.
+	logger.Flush()
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "from values child", entries[0]["message"])
+	assert.Equal(t, "from named child", entries[1]["message"])
+}
+
+func Test_Stats(t *testing.T) {
+	opts, _ := newTestOptions(t)
+	logger := log.New(opts)
+
+	for i := 0; i < 150; i++ {
+		logger.Info("sampled message")
+	}
+	logger.WithName("child").Error("error message")
+
+	stats := logger.Stats()
+	assert.Equal(t, uint64(100), stats.Written["info"])
+	assert.Equal(t, uint64(50), stats.SampledOut["info"])
+	assert.Equal(t, uint64(1), stats.Written["error"])
+	assert.Equal(t, uint64(0), stats.SampledOut["error"])
+	assert.Equal(t, uint64(0), stats.DroppedOnFull["info"])
+}
+
+func Test_LogSizeAccounting(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.LogSizeAccounting = true
+	logger := log.New(opts)
+
+	logger.Info("short")
+	logger.Info("a somewhat longer message", log.String("key", "value"))
+	logger.Warn("warning")
+	logger.Flush()
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	lines := strings.SplitAfter(string(data), "\n")
+	assert.Len(t, lines, 4) // the last one is empty
+
+	stats := logger.Stats()
+	assert.Equal(t, uint64(len(lines[0])+len(lines[1])), stats.Bytes["info"])
+	assert.Equal(t, uint64(len(lines[2])), stats.Bytes["warn"])
+	assert.Equal(t, uint64(0), stats.Bytes["error"])
+}
+
+type diffConfig struct {
+	Name     string            `log:"name"`
+	Replicas int               `log:"replicas"`
+	Password string            `log:"password" redact:"true"`
+	Internal string            `log:"-"`
+	Labels   map[string]string `log:"labels"`
+	Updated  time.Time
+}
+
+func Test_Diff(t *testing.T) {
+	opts, path := newTestOptions(t)
+	logger := log.New(opts)
+
+	now := time.Now()
+	before := diffConfig{Name: "api", Replicas: 2, Password: "old", Internal: "a", Updated: now,
+		Labels: map[string]string{"team": "core", "tier": "web"}}
+	after := before
+	after.Replicas = 3
+	after.Password = "new"
+	after.Internal = "b"
+	after.Labels = map[string]string{"team": "core", "env": "prod"}
+
+	logger.Diff("config changed", before, &after)
+	logger.Diff("config unchanged", before, before)
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"field": "replicas", "old": float64(2), "new": float64(3)},
+		map[string]interface{}{"field": "password", "old": "[REDACTED]", "new": "[REDACTED]"},
+		map[string]interface{}{"field": "labels.tier", "old": "web", "removed": true},
+		map[string]interface{}{"field": "labels.env", "new": "prod", "added": true},
+	}, entries[0]["changes"])
+	assert.Equal(t, []interface{}{}, entries[1]["changes"])
+}
+
+func Test_MaxEntryBytes(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.MaxEntryBytes = 1024
+	logger := log.New(opts).WithValues("request_id", "r-1")
+
+	logger.Info("small entry")
+	logger.Info("huge entry", log.String("payload", strings.Repeat("x", 1<<20)))
+	logger.WithValues("context", strings.Repeat("y", 4096)).Info("huge context")
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, "r-1", entries[0]["request_id"])
+	assert.NotContains(t, entries[0], "entry_truncated")
+
+	assert.Equal(t, "huge entry", entries[1]["message"])
+	assert.Equal(t, true, entries[1]["entry_truncated"])
+	assert.Greater(t, entries[1]["entry_bytes"], float64(1<<20))
+	assert.NotContains(t, entries[1], "payload")
+
+	assert.Equal(t, "huge context", entries[2]["message"])
+	assert.NotContains(t, entries[2], "context")
+
+	// The strings are known to exceed the limit, the entry is not encoded.
+	var allocs runtime.MemStats
+	runtime.ReadMemStats(&allocs)
+	before := allocs.TotalAlloc
+	logger.Info("huge again", log.String("payload", strings.Repeat("x", 8<<20)))
+	runtime.ReadMemStats(&allocs)
+	assert.Less(t, allocs.TotalAlloc-before, uint64(12<<20))
+
+	// Marshaled values are only known to be oversized once encoded.
+	logger.Info("huge object", log.Any("payload", map[string]string{"x": strings.Repeat("x", 4096)}))
+	logger.Flush()
+	entries = readEntries(t, path)
+	assert.Len(t, entries, 5)
+	assert.Equal(t, true, entries[4]["entry_truncated"])
+	assert.Greater(t, entries[4]["entry_bytes"], float64(4096))
+}
+
+func Test_Metric(t *testing.T) {
+	opts, path := newTestOptions(t)
+	logger := log.New(opts)
+
+	logger.Counter("requests_total", 1, map[string]string{"method": "GET"})
+	logger.Gauge("queue_depth", 42.5, nil)
+	logger.Metric("latency_ms", 12, map[string]string{"route": "/users"})
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 3)
+	for _, entry := range entries {
+		assert.Equal(t, "metric", entry["message"])
+		assert.Equal(t, "INFO", entry["level"])
+	}
+	assert.Equal(t, "requests_total", entries[0]["name"])
+	assert.Equal(t, float64(1), entries[0]["value"])
+	assert.Equal(t, map[string]interface{}{"method": "GET"}, entries[0]["tags"])
+	assert.Equal(t, "counter", entries[0]["type"])
+	assert.Equal(t, 42.5, entries[1]["value"])
+	assert.Equal(t, map[string]interface{}{}, entries[1]["tags"])
+	assert.Equal(t, "gauge", entries[1]["type"])
+	assert.Equal(t, "untyped", entries[2]["type"])
+}
+
+func Test_RingBuffer(t *testing.T) {
+	opts, _ := newTestOptions(t)
+	opts.RingBufferSize = 3
+	logger := log.New(opts)
+	assert.Empty(t, logger.RecentLogs())
+
+	logger.Info("message 0")
+	logger.Info("message 1")
+	assert.Len(t, logger.RecentLogs(), 2)
+
+	child := logger.WithValues("key", "value")
+	for i := 2; i < 6; i++ {
+		child.Info(fmt.Sprintf("message %d", i))
+	}
+
+	recent := logger.RecentLogs()
+	assert.Len(t, recent, 3)
+	for i, line := range recent {
+		entry := map[string]interface{}{}
+		assert.Nil(t, json.Unmarshal([]byte(line), &entry))
+		assert.Equal(t, fmt.Sprintf("message %d", i+3), entry["message"])
+		assert.Equal(t, "value", entry["key"])
+	}
+}
+
+func Test_ByteSize(t *testing.T) {
+	opts, path := newTestOptions(t)
+	logger := log.New(opts)
+
+	logger.Info("sizes",
+		log.ByteSize("zero", 0),
+		log.ByteSize("small", 512),
+		log.ByteSize("kib", 1536),
+		log.ByteSize("gib", 1<<30),
+		log.ByteSize("negative", -5<<20),
+	)
+	opts.RawByteSizes = true
+	log.New(opts).Info("raw", log.ByteSize("size", 2048), log.ByteSize("limit", 1<<40))
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "0 B", entries[0]["zero"])
+	assert.Equal(t, "512 B", entries[0]["small"])
+	assert.Equal(t, "1.5 KiB", entries[0]["kib"])
+	assert.Equal(t, "1.0 GiB", entries[0]["gib"])
+	assert.Equal(t, "-5.0 MiB", entries[0]["negative"])
+	assert.NotContains(t, entries[0], "gib_bytes")
+
+	assert.Equal(t, "2.0 KiB", entries[1]["size"])
+	assert.Equal(t, float64(2048), entries[1]["size_bytes"])
+	assert.Equal(t, "1.0 TiB", entries[1]["limit"])
+	assert.Equal(t, float64(1<<40), entries[1]["limit_bytes"])
+}
+
+func Test_NoSampleAbove(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.NoSampleAbove = "error"
+	logger := log.New(opts)
+
+	for i := 0; i < 150; i++ {
+		logger.Warn("sampled warning")
+		logger.Error("unsampled error")
+	}
+
+	counts := map[string]int{}
+	for _, entry := range readEntries(t, path) {
+		counts[entry["message"].(string)]++
+	}
+	assert.Equal(t, 100, counts["sampled warning"])
+	assert.Equal(t, 150, counts["unsampled error"])
+	assert.Equal(t, uint64(150), logger.Stats().Written["error"])
+}
+
+func deepPanic(logger log.Logger, depth int) {
+	if depth > 0 {
+		deepPanic(logger, depth-1)
+
+		return
+	}
+	logger.Panic("deep panic")
+}
+
+func Test_StacktraceDepth(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.DisablePanic = true
+	opts.StacktraceDepth = 3
+	logger := log.New(opts)
+
+	deepPanic(logger, 20)
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 1)
+	lines := strings.Split(entries[0]["stacktrace"].(string), "\n")
+	assert.Len(t, lines, 7)
+	assert.Contains(t, lines[0], "deepPanic")
+	assert.Regexp(t, `^\.\.\.\d+ more$`, lines[6])
+}
+
+func Test_MaskLongStrings(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.MaskLongStrings = 16
+	logger := log.New(opts)
+
+	logger.Info("masked",
+		log.String("token", "abcdefghijklmnopqrstuvwxyz"),
+		log.String("user", "alice"),
+		log.String("exact", "0123456789abcdef"),
+		log.Int("count", 12345678901234567),
+	)
+	logger.WithValues("session", "0123456789abcdefghij").Info("masked context")
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "abcd…wxyz", entries[0]["token"])
+	assert.Equal(t, "alice", entries[0]["user"])
+	assert.Equal(t, "0123456789abcdef", entries[0]["exact"])
+	assert.Equal(t, float64(12345678901234567), entries[0]["count"])
+	assert.Equal(t, "0123…ghij", entries[1]["session"])
+
+	opts.MaskLongStrings = 4
+	assert.NotEmpty(t, opts.Validate())
+}
+
+func Test_PIIRedaction(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.PIIRedaction = true
+	logger := log.New(opts)
+
+	logger.Info("signup",
+		log.String("contact", "reach me at alice@example.com please"),
+		log.String("ssn", "123-45-6789"),
+		log.String("card", "4111 1111 1111 1111"),
+		log.String("user", "alice"),
+	)
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "reach me at [PII] please", entries[0]["contact"])
+	assert.Equal(t, "[PII]", entries[0]["ssn"])
+	assert.Equal(t, "[PII]", entries[0]["card"])
+	assert.Equal(t, "alice", entries[0]["user"])
+
+	opts.PIIPatterns = []string{"("}
+	assert.NotEmpty(t, opts.Validate())
+}
+
+func Test_FlagEval(t *testing.T) {
+	opts, path := newTestOptions(t)
+	log.New(opts).FlagEval("new-checkout", true, "dropped at debug")
+	opts.FlagEvalLevel = "info"
+	log.New(opts).FlagEval("new-checkout", true, "rollout 50%")
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "flag_eval", entries[0]["message"])
+	assert.Equal(t, "INFO", entries[0]["level"])
+	assert.Equal(t, "new-checkout", entries[0]["flag"])
+	assert.Equal(t, true, entries[0]["result"])
+	assert.Equal(t, "rollout 50%", entries[0]["reason"])
+	assert.Contains(t, entries[0]["caller"], "log_test.go")
+}
+
+func Test_RequestScopedSampling(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.RequestScopedSampling = 2
+	logger := log.New(opts)
+
+	first := logger.WithContext(context.Background())
+	second := logger.WithContext(context.Background())
+	for i := 0; i < 5; i++ {
+		log.FromContext(first).Info("retrying")
+		log.FromContext(second).WithValues("request", "second").Info("retrying")
+	}
+	// storing the logger again keeps the counters of the request.
+	log.FromContext(log.FromContext(first).WithContext(first)).Info("retrying")
+	log.FromContext(first).Info("other message")
+	logger.Info("retrying")
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 6)
+	counts := map[string]int{}
+	for _, entry := range entries {
+		key := entry["message"].(string)
+		if entry["request"] != nil {
+			key += " " + entry["request"].(string)
+		}
+		counts[key]++
+	}
+	assert.Equal(t, map[string]int{"retrying": 3, "retrying second": 2, "other message": 1}, counts)
+	assert.Equal(t, uint64(7), logger.Stats().SampledOut["info"])
+}
+
+func Test_ErrorChain(t *testing.T) {
+	opts, path := newTestOptions(t)
+	logger := log.New(opts)
+
+	root := os.ErrNotExist
+	err := fmt.Errorf("loading config: %w", fmt.Errorf("opening file: %w", root))
+	logger.Error("failed", log.ErrorChain("chain", err))
+	logger.Error("joined", log.ErrorChain("chain", errors.Join(errors.New("first"), err)))
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"msg": "loading config: opening file: file does not exist", "type": "*fmt.wrapError"},
+		map[string]interface{}{"msg": "opening file: file does not exist", "type": "*fmt.wrapError"},
+		map[string]interface{}{"msg": "file does not exist", "type": "*errors.errorString"},
+	}, entries[0]["chain"])
+
+	chain := entries[1]["chain"].([]interface{})
+	assert.Len(t, chain, 5)
+	assert.Equal(t, "*errors.joinError", chain[0].(map[string]interface{})["type"])
+	assert.Equal(t, "first", chain[1].(map[string]interface{})["msg"])
+	assert.Equal(t, "file does not exist", chain[4].(map[string]interface{})["msg"])
+}
+
+func Test_InfofNoArgs(t *testing.T) {
+	opts, path := newTestOptions(t)
+	logger := log.New(opts)
+
+	// without arguments the format is logged as is, like the sugared logger does.
+	logger.Infof("100%% done")
+	logger.Infof("%s done", "100%")
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "100%% done", entries[0]["message"])
+	assert.Equal(t, "100% done", entries[1]["message"])
+}
+
+// fakeClock is a zapcore.Clock whose time and tickers only move when told to.
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	ticks chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Now(), ticks: make(chan time.Time)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) NewTicker(time.Duration) *time.Ticker { return &time.Ticker{C: c.ticks} }
+
+func (c *fakeClock) Tick() { c.ticks <- time.Now() }
+
+func Test_WatchLevelFile(t *testing.T) {
+	clock := newFakeClock()
+	opts, path := newTestOptions(t)
+	opts.Clock = clock
+	logger := log.New(opts)
+	dir := t.TempDir()
+	levelFile := filepath.Join(dir, "loglevel")
+	// Replace the file atomically, the watcher may be polling it.
+	writeLevel := func(level string) {
+		tmp := filepath.Join(dir, "loglevel.tmp")
+		assert.Nil(t, os.WriteFile(tmp, []byte(level+"\n"), 0o600))
+		assert.Nil(t, os.Rename(tmp, levelFile))
+	}
+	writeLevel("warn")
+
+	stop := logger.WatchLevelFile(levelFile)
+	defer stop()
+	logger.Info("dropped at warn")
+
+	writeLevel("debug")
+	// The second tick is only received once the first poll is done.
+	clock.Tick()
+	clock.Tick()
+	logger.Debug("written at debug")
+
+	writeLevel("loud")
+	clock.Tick()
+	clock.Tick()
+	logger.Debug("still written at debug")
+	logger.Flush()
+
+	var messages []string
+	for _, entry := range readEntries(t, path) {
+		messages = append(messages, entry["message"].(string))
+	}
+	assert.Equal(t, []string{
+		"log level changed",
+		"written at debug",
+		"invalid log level in file, keeping the current level",
+		"still written at debug",
+	}, messages)
+}
+
+func Test_WatchConfig(t *testing.T) {
+	clock := newFakeClock()
+	opts, path := newTestOptions(t)
+	opts.Clock = clock
+	logger := log.New(opts)
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "log.json")
+	// Replace the file atomically, the watcher may be polling it.
+	writeConfig := func(config string) {
+		tmp := filepath.Join(dir, "log.json.tmp")
+		assert.Nil(t, os.WriteFile(tmp, []byte(config), 0o600))
+		assert.Nil(t, os.Rename(tmp, configFile))
+	}
+
+	writeConfig(`{"level": "loud"}`)
+	_, err := logger.WatchConfig(configFile)
+	assert.NotNil(t, err)
+
+	writeConfig(`{"level": "warn", "format": "json"}`)
+	stop, err := logger.WatchConfig(configFile)
+	assert.Nil(t, err)
+	defer stop()
+	logger.Info("dropped at warn")
+
+	writeConfig(`{"level": "debug", "format": "json"}`)
+	// The second tick is only received once the first poll is done.
+	clock.Tick()
+	clock.Tick()
+	logger.Debug("written at debug")
+
+	writeConfig(`{"level": "error", "format": `)
+	clock.Tick()
+	clock.Tick()
+	logger.Debug("still written at debug")
+	logger.Flush()
+
+	var messages []string
+	for _, entry := range readEntries(t, path) {
+		messages = append(messages, entry["message"].(string))
+	}
+	assert.Equal(t, []string{
+		"log level changed",
+		"written at debug",
+		"failed to reload log config, keeping the current one",
+		"still written at debug",
+	}, messages)
+}
+
+func Test_Heartbeat(t *testing.T) {
+	clock := newFakeClock()
+	opts, path := newTestOptions(t)
+	opts.Clock = clock
+	logger := log.New(opts)
+
+	stop := logger.Heartbeat(time.Minute, log.WarnLevel)
+	assert.Empty(t, readEntries(t, path))
+	for i := 0; i < 3; i++ {
+		clock.Tick()
+	}
+	assert.Eventually(t, func() bool { return len(readEntries(t, path)) == 3 }, time.Second, 10*time.Millisecond)
+	stop()
+
+	entries := readEntries(t, path)
+	for _, entry := range entries {
+		assert.Equal(t, "logger alive", entry["message"])
+		assert.Equal(t, "WARN", entry["level"])
+	}
+}
+
+func Test_SamplingReport(t *testing.T) {
+	clock := newFakeClock()
+	opts, path := newTestOptions(t)
+	opts.Clock = clock
+	opts.SamplingReportInterval = time.Minute
+	logger := log.New(opts)
+	defer logger.Close()
+
+	for i := 0; i < 150; i++ {
+		logger.Info("sampled message")
+	}
+	logger.Info("other message")
+	assert.Len(t, readEntries(t, path), 101)
+
+	clock.Tick()
+	assert.Eventually(t, func() bool { return len(readEntries(t, path)) == 102 }, time.Second, 10*time.Millisecond)
+
+	report := readEntries(t, path)[101]
+	assert.Equal(t, "sampling report", report["message"])
+	assert.Equal(t, float64(101), report["kept"])
+	assert.Equal(t, float64(50), report["dropped"])
+	assert.Equal(t, float64(2), report["messages"])
+}
+
+func Test_ShutdownMessage(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.ShutdownMessage = "logger closing"
+	logger := log.New(opts)
+
+	logger.Info("Hello world!")
+	logger.Close()
+	logger.Close()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "logger closing", entries[1]["message"])
+	assert.Equal(t, float64(1), entries[1]["written"])
+	assert.NotNil(t, entries[1]["uptime"])
+}
+
+type auditContextKey struct{}
+
+func Test_ContextKey(t *testing.T) {
+	opts, path := newTestOptions(t)
+	auditOpts, auditPath := newTestOptions(t)
+	auditOpts.ContextKey = auditContextKey{}
+
+	ctx := log.New(opts).WithContext(context.Background())
+	ctx = log.New(auditOpts).WithContext(ctx)
+
+	log.FromContext(ctx).Info("to default logger")
+	log.FromContextWithKey(ctx, auditContextKey{}).Info("to audit logger")
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "to default logger", entries[0]["message"])
+	auditEntries := readEntries(t, auditPath)
+	assert.Len(t, auditEntries, 1)
+	assert.Equal(t, "to audit logger", auditEntries[0]["message"])
+}
+
+func Test_FromContextStdKey(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.ContextKey = auditContextKey{}
+	log.Init(opts)
+	defer log.Init(log.NewOptions())
+
+	ctx := log.WithValues("from", "std").WithContext(context.Background())
+	log.FromContext(ctx).Info("stored under the std key")
+
+	// A value which is not a logger falls back to the std logger.
+	ctx = context.WithValue(context.Background(), auditContextKey{}, "not a logger")
+	log.FromContext(ctx).Info("not a logger")
+	log.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "std", entries[0]["from"])
+	assert.Equal(t, "not a logger", entries[1]["message"])
+}
+
+func Test_BuildShutdown(t *testing.T) {
+	defer zap.ReplaceGlobals(zap.L())
+	defer stdlog.SetOutput(os.Stderr)
+
+	opts, path := newTestOptions(t)
+	opts.ShutdownMessage = "logger closing"
+	assert.Nil(t, opts.Build())
+
+	zap.L().Info("Hello world!")
+	log.Shutdown()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "Hello world!", entries[0]["message"])
+	assert.Equal(t, "logger closing", entries[1]["message"])
+}
+
+func Test_FieldsFromEnv(t *testing.T) {
+	t.Setenv("LOGFIELD_SERVICE", "payments")
+	t.Setenv("LOGFIELD_REGION", "eu-west-1")
+
+	opts, path := newTestOptions(t)
+	opts.FieldsFromEnv = "LOGFIELD_"
+	log.New(opts).Info("Hello world!")
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "payments", entries[0]["service"])
+	assert.Equal(t, "eu-west-1", entries[0]["region"])
+}
+
+func Test_MaxFields(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.MaxFields = 3
+	logger := log.New(opts)
+
+	var fields []log.Field
+	for i := 0; i < 6; i++ {
+		fields = append(fields, log.Int(fmt.Sprintf("field%d", i), i))
+	}
+	logger.Info("too many fields", fields...)
+	logger.Info("few fields", fields[:3]...)
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	// message, level, timestamp, caller, three fields and the marker
+	assert.Len(t, entries[0], 8)
+	assert.Equal(t, float64(2), entries[0]["field2"])
+	assert.Nil(t, entries[0]["field3"])
+	assert.Equal(t, true, entries[0]["fields_truncated"])
+	assert.Nil(t, entries[1]["fields_truncated"])
+}
+
+func Test_FatalGracePeriod(t *testing.T) {
+	sink, path := newTestSink(t)
+	sink.syncDelay = 50 * time.Millisecond
+
+	opts := log.NewOptions()
+	opts.OutputPaths = []string{path}
+	opts.DisableFatalExit = true
+	opts.FatalGracePeriod = time.Second
+	logger := log.New(opts)
+
+	start := time.Now()
+	logger.Fatal("fatal message")
+	assert.True(t, sink.Synced())
+	assert.GreaterOrEqual(t, time.Since(start), sink.syncDelay)
+	assert.Contains(t, sink.String(), "fatal message")
+}
+
+type recordingT struct {
+	lines []string
+}
+
+func (t *recordingT) Logf(format string, args ...interface{}) {
+	t.lines = append(t.lines, fmt.Sprintf(format, args...))
+}
+
+func Test_SetTestOutput(t *testing.T) {
+	rec := &recordingT{}
+	restore := log.SetTestOutput(rec)
+	log.Debug("debug message")
+	log.Infow("info message", "key", "value")
+	restore()
+	log.Info("after restore")
+
+	assert.Len(t, rec.lines, 2)
+	assert.Contains(t, rec.lines[0], "debug message")
+	assert.Contains(t, rec.lines[1], "info message")
+	assert.Contains(t, rec.lines[1], `"key": "value"`)
+}
+
+func Test_SetTestOutputStdLog(t *testing.T) {
+	var buf bytes.Buffer
+	stdlog.SetOutput(&buf)
+	defer stdlog.SetOutput(os.Stderr)
+
+	rec := &recordingT{}
+	restore := log.SetTestOutput(rec)
+	stdlog.Print("standard library message")
+	restore()
+
+	assert.Empty(t, rec.lines)
+	assert.Contains(t, buf.String(), "standard library message")
+}
+
+func Test_LevelEnabler(t *testing.T) {
+	var debug atomic.Bool
+	opts, path := newTestOptions(t)
+	opts.LevelEnabler = func(lvl log.Level) bool {
+		return lvl >= log.InfoLevel || debug.Load()
+	}
+	logger := log.New(opts)
+
+	logger.Debug("dropped debug")
+	logger.Info("info")
+	debug.Store(true)
+	logger.Debug("enabled debug")
+	debug.Store(false)
+	logger.Debug("dropped again")
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "info", entries[0]["message"])
+	assert.Equal(t, "enabled debug", entries[1]["message"])
+}
+
+func Test_Go(t *testing.T) {
+	opts, path := newTestOptions(t)
+	logger := log.New(opts).WithValues(log.KeyRequestID, "abc")
+
+	logger.Go(func(l log.Logger) {
+		l.Info("in goroutine")
+		panic("boom")
+	})
+
+	assert.Eventually(t, func() bool { return len(readEntries(t, path)) == 2 }, time.Second, 10*time.Millisecond)
+	entries := readEntries(t, path)
+	assert.Equal(t, "in goroutine", entries[0]["message"])
+	assert.Equal(t, "abc", entries[0][log.KeyRequestID])
+	assert.Equal(t, "ERROR", entries[1]["level"])
+	assert.Equal(t, "boom", entries[1]["panic"])
+	assert.Equal(t, "abc", entries[1][log.KeyRequestID])
+	assert.NotEmpty(t, entries[1]["stack"])
+}
+
+func Test_CaptureFormatArgs(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.CaptureFormatArgs = true
+	logger := log.New(opts)
+
+	logger.Infof("user %s did %s", "Alice", "login")
+	logger.Warnf("retry %d of %d", 1, 3)
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "user Alice did login", entries[0]["message"])
+	assert.Equal(t, []interface{}{"Alice", "login"}, entries[0]["args"])
+	assert.Equal(t, "retry 1 of 3", entries[1]["message"])
+	assert.Equal(t, []interface{}{float64(1), float64(3)}, entries[1]["args"])
+	assert.Contains(t, entries[0]["caller"], "log_test.go")
+}
+
+func Test_InitialFields(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.InitialFields = map[string]interface{}{
+		"service":  "payments",
+		"region":   "eu-west-1",
+		"instance": "i-0123",
+	}
+	log.New(opts).WithValues("key", "value").Info("from child")
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "payments", entries[0]["service"])
+	assert.Equal(t, "eu-west-1", entries[0]["region"])
+	assert.Equal(t, "i-0123", entries[0]["instance"])
+	assert.Equal(t, "value", entries[0]["key"])
+}
+
+func Test_ErrorCooldown(t *testing.T) {
+	clock := newFakeClock()
+	opts, path := newTestOptions(t)
+	opts.Clock = clock
+	opts.ErrorCooldown = time.Minute
+	logger := log.New(opts)
+
+	for i := 0; i < 5; i++ {
+		logger.Error("connection refused")
+		logger.Info("not an error")
+		clock.Advance(time.Second)
+	}
+	logger.Error("other error")
+	clock.Advance(time.Minute)
+	logger.Error("connection refused")
+
+	var errors []map[string]interface{}
+	for _, entry := range readEntries(t, path) {
+		if entry["level"] == "ERROR" {
+			errors = append(errors, entry)
+		}
+	}
+	assert.Len(t, errors, 3)
+	assert.Equal(t, "connection refused", errors[0]["message"])
+	assert.Nil(t, errors[0]["suppressed"])
+	assert.Equal(t, "other error", errors[1]["message"])
+	assert.Equal(t, "connection refused", errors[2]["message"])
+	assert.Equal(t, float64(4), errors[2]["suppressed"])
+	assert.Equal(t, uint64(4), logger.Stats().Deduped["error"])
+	assert.Equal(t, uint64(5), logger.Stats().Written["info"])
+}
+
+func Test_ErrorCooldownSummary(t *testing.T) {
+	clock := newFakeClock()
+	opts, path := newTestOptions(t)
+	opts.Clock = clock
+	opts.ErrorCooldown = time.Minute
+	logger := log.New(opts)
+
+	for i := 0; i < 3; i++ {
+		logger.WithName("db").Errorw("connection refused", "attempt", i)
+		clock.Advance(time.Second)
+	}
+	// the error does not come back, its count is written once the cooldown
+	// is over and something else is logged
+	logger.Info("before expiry")
+	clock.Advance(time.Minute)
+	logger.Info("after expiry")
+	logger.Info("no second summary")
+
+	// pending counts are flushed by Sync
+	logger.Error("timeout")
+	logger.Error("timeout")
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	var messages []interface{}
+	for _, entry := range entries {
+		messages = append(messages, entry["message"])
+	}
+	assert.Equal(t, []interface{}{
+		"connection refused", "before expiry", "connection refused", "after expiry", "no second summary", "timeout", "timeout",
+	}, messages)
+	assert.Equal(t, float64(2), entries[2]["suppressed"])
+	assert.Equal(t, float64(1), entries[2]["attempt"])
+	assert.Equal(t, "db", entries[2]["logger"])
+	assert.Equal(t, float64(1), entries[6]["suppressed"])
+	assert.Equal(t, uint64(3), logger.Stats().Deduped["error"])
+}
+
+func Test_TwoLevelCallerEncoder(t *testing.T) {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.EncodeCaller = log.TwoLevelCallerEncoder
+	enc := zapcore.NewJSONEncoder(cfg)
+
+	for file, expected := range map[string]string{
+		"/home/user/monorepo/services/payments/internal/store/db.go": "internal/store/db.go:42",
+		"payments/store/db.go": "payments/store/db.go:42",
+		"store/db.go":          "store/db.go:42",
+		"/main.go":             "main.go:42",
+		"/cmd/app/main.go":     "cmd/app/main.go:42",
+	} {
+		buf, err := enc.EncodeEntry(zapcore.Entry{
+			Caller: zapcore.NewEntryCaller(0, file, 42, true),
+		}, nil)
+		assert.Nil(t, err)
+		assert.Contains(t, buf.String(), `"caller":"`+expected+`"`)
+	}
+}
+
+func Test_CallerEncoderValidate(t *testing.T) {
+	opts := log.NewOptions()
+	opts.CallerEncoder = "twolevel"
+	assert.Empty(t, opts.Validate())
+	opts.CallerEncoder = "long"
+	assert.Len(t, opts.Validate(), 1)
+}
+
+func Test_WriteTimeout(t *testing.T) {
+	sink, path := newTestSink(t)
+	sink.writeDelay = 200 * time.Millisecond
+	errSink, errPath := newTestSink(t)
+
+	opts := log.NewOptions()
+	opts.OutputPaths = []string{path}
+	opts.ErrorOutputPaths = []string{errPath}
+	opts.WriteTimeout = 20 * time.Millisecond
+	logger := log.New(opts)
+
+	start := time.Now()
+	logger.Info("slow write")
+	logger.Info("dropped write")
+	assert.Less(t, time.Since(start), sink.writeDelay)
+	assert.Contains(t, errSink.String(), "timed out")
+	assert.Contains(t, errSink.String(), "dropped")
+
+	// the timed out write still completes in the background
+	assert.Eventually(t, func() bool { return strings.Contains(sink.String(), "slow write") }, time.Second, 10*time.Millisecond)
+	assert.NotContains(t, sink.String(), "dropped write")
+}
+
+func Test_WriteTimeoutSaturated(t *testing.T) {
+	sink, path := newTestSink(t)
+	sink.writeDelay = 200 * time.Millisecond
+	errSink, errPath := newTestSink(t)
+
+	opts := log.NewOptions()
+	opts.OutputPaths = []string{path}
+	opts.ErrorOutputPaths = []string{errPath}
+	opts.WriteTimeout = 20 * time.Millisecond
+	logger := log.New(opts)
+
+	logger.Info("slow write")
+	goroutines := runtime.NumGoroutine()
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		logger.Info("dropped write")
+	}
+	// the writes are dropped at once, without waiting for the timeout
+	assert.Less(t, time.Since(start), opts.WriteTimeout*5)
+	assert.LessOrEqual(t, runtime.NumGoroutine(), goroutines)
+	assert.Contains(t, errSink.String(), "100 dropped so far")
+	assert.Equal(t, uint64(100), logger.Stats().DroppedOnFull["info"])
+
+	assert.Eventually(t, func() bool { return strings.Contains(sink.String(), "slow write") }, time.Second, 10*time.Millisecond)
+	assert.NotContains(t, sink.String(), "dropped write")
+}
+
+func Test_WriteRetry(t *testing.T) {
+	sink, path := newTestSink(t)
+	errSink, errPath := newTestSink(t)
+
+	opts := log.NewOptions()
+	opts.OutputPaths = []string{path}
+	opts.ErrorOutputPaths = []string{errPath}
+	opts.WriteRetryAttempts = 2
+	opts.WriteRetryBackoff = time.Millisecond
+	logger := log.New(opts)
+
+	sink.failures = 1
+	logger.Info("retried write")
+	assert.Contains(t, sink.String(), "retried write")
+	assert.Empty(t, errSink.String())
+
+	sink.failures = 3
+	logger.Info("failed write")
+	assert.NotContains(t, sink.String(), "failed write")
+	assert.Contains(t, errSink.String(), "transient failure")
+}
+
+func Test_WriteRetryTimeout(t *testing.T) {
+	sink, path := newTestSink(t)
+	sink.writeDelay = 30 * time.Millisecond
+	errSink, errPath := newTestSink(t)
+
+	opts := log.NewOptions()
+	opts.OutputPaths = []string{path}
+	opts.ErrorOutputPaths = []string{errPath}
+	opts.WriteTimeout = 20 * time.Millisecond
+	opts.WriteRetryAttempts = 2
+	opts.WriteRetryBackoff = 15 * time.Millisecond
+	logger := log.New(opts)
+
+	logger.Info("slow write")
+	assert.Contains(t, errSink.String(), "timed out")
+
+	// the timed out write succeeds in the background and is not written again
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, 1, strings.Count(sink.String(), "slow write"))
+}
+
+func Test_FallbackOutput(t *testing.T) {
+	sink, path := newTestSink(t)
+	fallback, fallbackPath := newTestSink(t)
+	errSink, errPath := newTestSink(t)
+
+	opts := log.NewOptions()
+	opts.OutputPaths = []string{path}
+	opts.ErrorOutputPaths = []string{errPath}
+	opts.FallbackOutputPath = fallbackPath
+	logger := log.New(opts)
+
+	logger.Info("primary write")
+	sink.failures = 2
+	logger.Info("first fallback write")
+	logger.Info("second fallback write")
+
+	assert.Contains(t, sink.String(), "primary write")
+	assert.NotContains(t, sink.String(), "fallback write")
+	assert.Equal(t, 1, strings.Count(fallback.String(), "failed, writing to the fallback output"))
+	assert.Contains(t, fallback.String(), "first fallback write")
+	assert.Contains(t, fallback.String(), "second fallback write")
+	assert.Empty(t, errSink.String())
+}
+
+func Test_Sinks(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	received := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			received <- scanner.Text()
+		}
+	}()
+
+	stdout, err := os.CreateTemp(t.TempDir(), "stdout")
+	assert.Nil(t, err)
+	defer stdout.Close()
+	filePath := filepath.Join(t.TempDir(), "debug.log")
+
+	opts := log.Sinks().
+		Console(log.InfoLevel).
+		File(filePath, log.DebugLevel).
+		Network(listener.Addr().String(), log.ErrorLevel).
+		Options()
+	opts.Format = "json"
+	assert.Empty(t, opts.Validate())
+	origStdout := os.Stdout
+	os.Stdout = stdout
+	logger := log.New(opts)
+	os.Stdout = origStdout
+
+	logger.Debug("debug message")
+	logger.Info("info message")
+	logger.Error("error message")
+	logger.Flush()
+
+	messages := func(path string) []string {
+		var out []string
+		for _, entry := range readEntries(t, path) {
+			out = append(out, entry["message"].(string))
+		}
+
+		return out
+	}
+	assert.Equal(t, []string{"debug message", "info message", "error message"}, messages(filePath))
+	assert.Equal(t, []string{"info message", "error message"}, messages(stdout.Name()))
+	select {
+	case line := <-received:
+		assert.Contains(t, line, "error message")
+	case <-time.After(time.Second):
+		t.Fatal("no entry received by the network sink")
+	}
+	assert.Empty(t, received)
+
+	opts.Sinks = append(opts.Sinks, log.SinkOptions{Path: "", Level: "loud"})
+	assert.Len(t, opts.Validate(), 2)
+	opts.Sinks[len(opts.Sinks)-1] = log.SinkOptions{Path: "", Level: "warn"}
+	assert.Len(t, opts.Validate(), 1)
+	opts.Sinks[len(opts.Sinks)-1] = log.SinkOptions{Path: "stdout", Level: "loud"}
+	assert.Len(t, opts.Validate(), 1)
+}
+
+func Test_SinksCollectorDown(t *testing.T) {
+	// a free port nothing listens on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	opts := log.Sinks().Network(addr, log.InfoLevel).Options()
+	opts.Format = "json"
+	assert.Empty(t, opts.Validate())
+	var logger log.Logger
+	assert.NotPanics(t, func() { logger = log.New(opts) })
+	logger.Info("lost message")
+	logger.Flush()
+
+	// the collector comes up later
+	listener, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("port %s taken again: %v", addr, err)
+	}
+	defer listener.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		if scanner.Scan() {
+			received <- scanner.Text()
+		}
+	}()
+	logger.Info("delivered message")
+	logger.Flush()
+	select {
+	case line := <-received:
+		assert.Contains(t, line, "delivered message")
+	case <-time.After(time.Second):
+		t.Fatal("no entry received once the collector is up")
+	}
+}
+
+func Test_Audit(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.Level = "error"
+	opts.BufferSize = 1 << 20
+	opts.AuditOutputPath = filepath.Join(t.TempDir(), "audit.log")
+	logger := log.New(opts)
+	defer logger.Close()
+
+	audit := logger.Audit().WithValues("user", "alice")
+	for i := 0; i < 200; i++ {
+		logger.Error("app event")
+		audit.Info("login")
+	}
+
+	entries := readEntries(t, opts.AuditOutputPath)
+	assert.Len(t, entries, 200)
+	assert.Equal(t, "login", entries[0]["message"])
+	assert.Equal(t, "alice", entries[0]["user"])
+	assert.Equal(t, "audit", entries[0]["logger"])
+	assert.Empty(t, readEntries(t, path))
+
+	// the main logger keeps the first 100 then every 100th.
+	logger.Flush()
+	assert.Len(t, readEntries(t, path), 101)
+
+	// the audit logger keeps the names and fields of the calling logger
+	type auditor interface{ Audit() log.Logger }
+	derived := logger.WithName("auth").WithValues("user", "bob").WithComponent("session")
+	derived.(auditor).Audit().Info("logout")
+	entries = readEntries(t, opts.AuditOutputPath)
+	last := entries[len(entries)-1]
+	assert.Equal(t, "logout", last["message"])
+	assert.Equal(t, "bob", last["user"])
+	assert.Equal(t, "session", last["component"])
+	assert.Equal(t, "audit.auth", last["logger"])
+	assert.Contains(t, last, "caller")
+
+	// and honours DisableCaller
+	opts.DisableCaller = true
+	uncalled := log.New(opts)
+	defer uncalled.Close()
+	uncalled.Audit().Info("without caller")
+	entries = readEntries(t, opts.AuditOutputPath)
+	assert.NotContains(t, entries[len(entries)-1], "caller")
+	opts.DisableCaller = false
+
+	// the audit logger honours the terminal hooks of the options
+	opts.DisableFatalExit = true
+	opts.DisablePanic = true
+	hooked := log.New(opts)
+	defer hooked.Close()
+	hooked.Audit().Fatal("audited fatal")
+	assert.NotPanics(t, func() { hooked.Audit().Panic("audited panic") })
+	entries = readEntries(t, opts.AuditOutputPath)
+	assert.Equal(t, "audited panic", entries[len(entries)-1]["message"])
+	opts.DisableFatalExit = false
+	opts.DisablePanic = false
+
+	opts.AuditOutputPath = ""
+	plain := log.New(opts)
+	defer plain.Close()
+	assert.True(t, plain.Audit() == log.Logger(plain))
+}
+
+// panickingMarshaler is an object marshaler which panics.
+type panickingMarshaler struct{}
+
+func (panickingMarshaler) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("half", "written")
+	panic("broken marshaler")
+}
+
+func Test_EncodeErrorRecovery(t *testing.T) {
+	errSink, errPath := newTestSink(t)
+	opts, path := newTestOptions(t)
+	opts.ErrorOutputPaths = []string{errPath}
+	opts.EncodeErrorRecovery = true
+	logger := log.New(opts)
+
+	logger.Info("still logged", log.Object("bad", panickingMarshaler{}), log.String("key", "value"),
+		log.Any("channel", make(chan int)))
+	logger.WithValues("ok", 1).Info("plain")
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "still logged", entries[0]["message"])
+	assert.Equal(t, "encode_error", entries[0]["bad"])
+	assert.Equal(t, "encode_error", entries[0]["channel"])
+	assert.Equal(t, "value", entries[0]["key"])
+	assert.Equal(t, float64(1), entries[1]["ok"])
+	assert.Contains(t, errSink.String(), `encode error for field "bad": panic: broken marshaler`)
+	assert.Contains(t, errSink.String(), `encode error for field "channel"`)
+}
+
+func Test_TimeFormat(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.TimeFormat = "epochnano"
+	assert.Empty(t, opts.Validate())
+	logger := log.New(opts)
+
+	before := time.Now().UnixNano()
+	logger.Info("first")
+	logger.Info("second")
+	after := time.Now().UnixNano()
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	var last int64
+	for _, entry := range entries {
+		n, ok := entry["timestamp"].(float64)
+		assert.True(t, ok)
+		// float64 loses the last digits of the nanoseconds
+		ts := int64(n)
+		assert.GreaterOrEqual(t, ts, before-1000)
+		assert.LessOrEqual(t, ts, after+1000)
+		assert.GreaterOrEqual(t, ts, last)
+		last = ts
+	}
+
+	opts, path = newTestOptions(t)
+	opts.TimeFormat = time.RFC3339
+	log.New(opts).Info("layout")
+	entries = readEntries(t, path)
+	_, err := time.Parse(time.RFC3339, entries[0]["timestamp"].(string))
+	assert.Nil(t, err)
+
+	for _, format := range []string{"epochnano 2006", "no layout"} {
+		opts.TimeFormat = format
+		assert.Len(t, opts.Validate(), 1, format)
+	}
+}
+
+func Test_Sync(t *testing.T) {
+	benign, benignPath := newTestSink(t)
+	benign.syncErr = &os.PathError{Op: "sync", Path: "/dev/stdout", Err: errors.New("invalid argument")}
+	failing, failingPath := newTestSink(t)
+
+	opts := log.NewOptions()
+	opts.OutputPaths = []string{benignPath, failingPath}
+	logger := log.New(opts)
+	logger.Info("synced")
+	assert.Nil(t, logger.Sync())
+
+	failing.syncErr = errors.New("disk full")
+	err := logger.Sync()
+	assert.NotNil(t, err)
+	assert.Equal(t, "disk full", err.Error())
+
+	opts.IgnoreSyncErrors = nil
+	err = log.New(opts).Sync()
+	assert.Contains(t, err.Error(), "sync /dev/stdout: invalid argument")
+	assert.Contains(t, err.Error(), "disk full")
+
+	opts.IgnoreSyncErrors = []string{"disk (full"}
+	assert.Len(t, opts.Validate(), 1)
+}
+
+func Test_LevelAliases(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.LevelAliases = map[string]log.Level{"verbose": log.DebugLevel, "critical": log.FatalLevel}
+
+	for text, want := range map[string]log.Level{"verbose": log.DebugLevel, "CRITICAL": log.FatalLevel, "warn": log.WarnLevel} {
+		lvl, err := opts.ParseLevel(text)
+		assert.Nil(t, err)
+		assert.Equal(t, want, lvl, text)
+	}
+	_, err := opts.ParseLevel("loud")
+	assert.NotNil(t, err)
+	_, err = log.ParseLevel("verbose")
+	assert.NotNil(t, err)
+
+	opts.Level = "verbose"
+	opts.NoSampleAbove = "critical"
+	assert.Empty(t, opts.Validate())
+	logger := log.New(opts)
+	logger.Debug("verbose message")
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "verbose message", entries[0]["message"])
+}
+
+func Test_MaxFieldLength(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.MaxFieldLength = 100
+	logger := log.New(opts)
+
+	payload := map[string]interface{}{}
+	for i := 0; i < 50; i++ {
+		payload[fmt.Sprintf("key%02d", i)] = strings.Repeat("v", i)
+	}
+	object, _ := json.Marshal(payload)
+	array, _ := json.Marshal(strings.Split(strings.Repeat("item ", 50), " "))
+	logger.Info("truncated", log.String("object", string(object)), log.String("array", string(array)),
+		log.String("plain", strings.Repeat("é", 60)), log.String("short", `{"a":1}`))
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 1)
+
+	truncated := entries[0]["object"].(string)
+	assert.LessOrEqual(t, len(truncated), 100)
+	decoded := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal([]byte(truncated), &decoded))
+	assert.Equal(t, "truncated", decoded["..."])
+	assert.Equal(t, "", decoded["key00"])
+
+	truncated = entries[0]["array"].(string)
+	assert.LessOrEqual(t, len(truncated), 100)
+	var items []string
+	assert.Nil(t, json.Unmarshal([]byte(truncated), &items))
+	assert.Equal(t, "...truncated", items[len(items)-1])
+
+	assert.Equal(t, strings.Repeat("é", 50), entries[0]["plain"])
+	assert.Equal(t, `{"a":1}`, entries[0]["short"])
+}
+
+func Test_SamplingDeterministic(t *testing.T) {
+	clock := newFakeClock()
+	opts, path := newTestOptions(t)
+	opts.Clock = clock
+	logger := log.New(opts)
+
+	for i := 0; i < 350; i++ {
+		logger.Info("same message", log.Int("i", i))
+	}
+	// the sampler counts again once a full second has passed
+	clock.Advance(2 * time.Second)
+	logger.Info("same message", log.Int("i", 350))
+	logger.Flush()
+
+	var kept []int
+	for _, entry := range readEntries(t, path) {
+		kept = append(kept, int(entry["i"].(float64)))
+	}
+	var want []int
+	for i := 0; i < 100; i++ {
+		want = append(want, i)
+	}
+	want = append(want, 199, 299, 350)
+	assert.Equal(t, want, kept)
+}
+
+func Test_SinkQueue(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	// the connection is accepted but never read, so that the writes block once
+	// the socket buffers are full.
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	filePath := filepath.Join(t.TempDir(), "app.log")
+	opts := log.Sinks().
+		File(filePath, log.InfoLevel).
+		Network(listener.Addr().String(), log.InfoLevel).
+		Options()
+	opts.Format = "json"
+	logger := log.New(opts)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		logger.Info("large entry", log.String("payload", strings.Repeat("x", 32<<20)))
+		for i := 0; i < 10; i++ {
+			logger.Info("file entry")
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the blocked network sink delayed the file writes")
+	}
+	data, err := os.ReadFile(filePath)
+	assert.Nil(t, err)
+	assert.Equal(t, 11, bytes.Count(data, []byte("\n")))
+	assert.Equal(t, 10, bytes.Count(data, []byte(`"message":"file entry"`)))
+
+	// unblock the pending network write
+	conn := <-accepted
+	conn.Close()
+}
+
+func Test_SinkQueueFullStats(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	opts := log.Sinks().Network(listener.Addr().String(), log.InfoLevel).Options()
+	opts.Format = "json"
+	opts.SinkQueueSize = 1
+	opts.ErrorOutputPaths = nil
+	logger := log.New(opts)
+
+	// the large entry blocks the queue goroutine, the queue holds one more
+	logger.Info("large entry", log.String("payload", strings.Repeat("x", 32<<20)))
+	for i := 0; i < 10; i++ {
+		logger.Info("queued entry")
+	}
+	assert.GreaterOrEqual(t, logger.Stats().DroppedOnFull["info"], uint64(8))
+	assert.Equal(t, uint64(11), logger.Stats().Written["info"])
+
+	conn := <-accepted
+	conn.Close()
+}
+
+func Test_StructuredStacktrace(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.StructuredStacktrace = true
+	opts.StacktraceDepth = 2
+	logger := log.New(opts)
+
+	func() {
+		defer func() { _ = recover() }()
+		logger.Panic("panic message")
+	}()
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 1)
+	frames, ok := entries[0]["stacktrace"].([]interface{})
+	assert.True(t, ok)
+	assert.Len(t, frames, 2)
+	frame := frames[0].(map[string]interface{})
+	assert.Contains(t, frame["function"], "Test_StructuredStacktrace")
+	assert.True(t, strings.HasSuffix(frame["file"].(string), "log_test.go"))
+	assert.Greater(t, frame["line"], float64(0))
+	assert.Greater(t, entries[0]["stacktrace_truncated"], float64(0))
+}
+
+func Test_FirstThenSample(t *testing.T) {
+	clock := newFakeClock()
+	opts, path := newTestOptions(t)
+	opts.Clock = clock
+	opts.FirstThenSample = 2
+	logger := log.New(opts)
+
+	for i := 0; i < 10; i++ {
+		logger.Info("busy path", log.Int("i", i))
+	}
+	logger.Info("quiet path")
+	clock.Advance(2 * time.Second)
+	logger.Info("busy path", log.Int("i", 10))
+	logger.Info("quiet path")
+	logger.Flush()
+
+	var messages []string
+	for _, entry := range readEntries(t, path) {
+		messages = append(messages, fmt.Sprint(entry["message"], entry["i"]))
+	}
+	assert.Equal(t, []string{"busy path0", "busy path1", "quiet path<nil>", "busy path10", "quiet path<nil>"}, messages)
+}
+
+func Test_ParseOutputPath(t *testing.T) {
+	for path, want := range map[string]log.OutputConfig{
+		"stdout":           {Path: "stdout"},
+		"stderr://":        {Path: "stderr"},
+		"/var/log/app.log": {Path: "/var/log/app.log"},
+		"file:///var/log/app.log?level=warn&timeout=500ms":  {Path: "/var/log/app.log", Level: "warn", WriteTimeout: 500 * time.Millisecond},
+		"tcp://collector:5000?queue=4096":                   {Path: "tcp://collector:5000", QueueSize: 4096},
+		"file:///var/log/app.log?maxsize=100&compress=true": {Path: "/var/log/app.log", Rotation: log.RotationConfig{MaxSize: 100, Compress: true}},
+		"file:///var/log/app.log?maxbackups=5&maxage=168h":  {Path: "/var/log/app.log", Rotation: log.RotationConfig{MaxBackups: 5, MaxAge: 168 * time.Hour}},
+	} {
+		cfg, err := log.ParseOutputPath(path)
+		assert.Nil(t, err, path)
+		assert.Equal(t, want, cfg, path)
+	}
+	for _, path := range []string{
+		"file:///var/log/app.log?maxsize=0",
+		"file:///var/log/app.log?compress=maybe",
+		"tcp://collector:5000?maxsize=100",
+		"file://host/var/log/app.log",
+		"stdout://somewhere",
+		"tcp://collector:5000?queue=none",
+		"file:///app.log?timeout=-1s",
+	} {
+		_, err := log.ParseOutputPath(path)
+		assert.NotNil(t, err, path)
+	}
+
+	dir := t.TempDir()
+	opts := log.NewOptions()
+	opts.Format = "json"
+	opts.Level = "debug"
+	opts.OutputPaths = []string{"file://" + filepath.Join(dir, "all.log"), "file://" + filepath.Join(dir, "warn.log") + "?level=warn"}
+	assert.Empty(t, opts.Validate())
+	logger := log.New(opts)
+	logger.Debug("debug message")
+	logger.Warn("warn message")
+	logger.Flush()
+	assert.Len(t, readEntries(t, filepath.Join(dir, "all.log")), 2)
+	assert.Len(t, readEntries(t, filepath.Join(dir, "warn.log")), 1)
+
+	opts.OutputPaths = []string{"file:///app.log?level=loud", "file:///app.log?rotate=daily"}
+	assert.Len(t, opts.Validate(), 2)
+
+	// rotation is parsed, but only fails once the output is opened
+	opts.OutputPaths = []string{"file://" + filepath.Join(dir, "rotated.log") + "?maxsize=100&compress=true"}
+	assert.Empty(t, opts.Validate())
+	err := opts.Build()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "rotation is not supported")
+}
+
+func Test_LogContextDone(t *testing.T) {
+	opts, path := newTestOptions(t)
+	logger := log.New(opts)
+
+	logger.LogContextDone(context.Background(), "not done")
+
+	timedOut, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-timedOut.Done()
+	logger.LogContextDone(timedOut, "request timed out")
+
+	cancelled, cancelCause := context.WithCancelCause(context.Background())
+	cancelCause(errors.New("client went away"))
+	logger.LogContextDone(cancelled, "request cancelled")
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "WARN", entries[0]["level"])
+	assert.Equal(t, "context deadline exceeded", entries[0]["ctx_err"])
+	assert.Equal(t, "context deadline exceeded", entries[0]["ctx_cause"])
+	assert.Equal(t, "request cancelled", entries[1]["message"])
+	assert.Equal(t, "context canceled", entries[1]["ctx_err"])
+	assert.Equal(t, "client went away", entries[1]["ctx_cause"])
+	assert.Contains(t, entries[1]["caller"], "log_test.go")
+}
+
+func Test_FormatMismatchCheck(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.FormatMismatchCheck = true
+	logger := log.New(opts)
+
+	logger.Infof("progress 100%s")
+	logger.Errorf("user %s failed: %v", "alice")
+	logger.Warnf("%d%% of %*d done", 50, 3, 10)
+	logger.Infof("all %s fine", "is")
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 4)
+	assert.Equal(t, "progress 100%s", entries[0]["message"])
+	assert.Equal(t, "1 verbs for 0 args", entries[0]["format_mismatch"])
+	assert.Equal(t, "user %s failed: %v", entries[1]["message"])
+	assert.Equal(t, "2 verbs for 1 args", entries[1]["format_mismatch"])
+	assert.Equal(t, []interface{}{"alice"}, entries[1]["args"])
+	assert.Equal(t, "50% of  10 done", entries[2]["message"])
+	assert.Nil(t, entries[2]["format_mismatch"])
+	assert.Equal(t, "all is fine", entries[3]["message"])
+	assert.Contains(t, entries[1]["caller"], "log_test.go")
+}
+
+func Test_SequenceNumbers(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.SequenceNumbers = true
+	logger := log.New(opts)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			child := logger.WithValues("goroutine", g)
+			for i := 0; i < 50; i++ {
+				child.Info(fmt.Sprintf("entry %d", i))
+			}
+		}(g)
+	}
+	wg.Wait()
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 400)
+	seen := map[int]bool{}
+	for _, entry := range entries {
+		seen[int(entry["seq"].(float64))] = true
+	}
+	for seq := 1; seq <= 400; seq++ {
+		assert.True(t, seen[seq], seq)
+	}
+}
+
+func Test_LevelFormats(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.Format = "console"
+	opts.LevelFormats = map[string]string{"error": "json"}
+	assert.Empty(t, opts.Validate())
+	logger := log.New(opts).WithValues("user", "alice")
+
+	logger.Info("info message")
+	logger.Error("error message")
+	logger.Flush()
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], "info message")
+	assert.False(t, json.Valid([]byte(lines[0])))
+	entry := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, "ERROR", entry["level"])
+	assert.Equal(t, "alice", entry["user"])
+
+	opts.LevelFormats = map[string]string{"loud": "yaml"}
+	assert.Len(t, opts.Validate(), 2)
+}
+
+func Test_ECSFormat(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.Format = "ecs"
+	assert.Empty(t, opts.Validate())
+	logger := log.New(opts).WithName("api").WithValues("request_id", "r-1")
+
+	logger.Info("info message")
+	logger.Error("error message", log.Err(errors.New("boom")))
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	for _, entry := range entries {
+		_, err := time.Parse(time.RFC3339Nano, entry["@timestamp"].(string))
+		assert.Nil(t, err)
+		assert.Equal(t, "8.11.0", entry["ecs.version"])
+		assert.Equal(t, "api", entry["log.logger"])
+		assert.Contains(t, entry["log.origin.file.name"], "log_test.go")
+		assert.Equal(t, "r-1", entry["request_id"])
+		assert.NotContains(t, entry, "timestamp")
+		assert.NotContains(t, entry, "level")
+	}
+	assert.Equal(t, "info", entries[0]["log.level"])
+	assert.Equal(t, "info message", entries[0]["message"])
+	assert.Equal(t, "error", entries[1]["log.level"])
+	assert.Equal(t, "boom", entries[1]["error.message"])
+}
+
+func Test_SamplingKeyLRU(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.Clock = newFakeClock()
+	opts.SamplingKey = "tenant"
+	opts.SamplingKeyRate = log.SampleRate{First: 1}
+	opts.SamplingKeyLRU = 2
+	logger := log.New(opts)
+
+	a := logger.WithValues("tenant", "a")
+	a.Info("request", log.Int("i", 0))
+	a.Info("request", log.Int("i", 1))
+	logger.Info("request", log.String("tenant", "b"), log.Int("i", 2))
+	logger.Info("request", log.String("tenant", "c"), log.Int("i", 3))
+	// a was evicted by c and restarts from the first entries.
+	a.Info("request", log.Int("i", 4))
+	logger.Info("untenanted", log.Int("i", 5))
+	logger.Flush()
+
+	var kept []string
+	for _, entry := range readEntries(t, path) {
+		kept = append(kept, fmt.Sprint(entry["tenant"], entry["i"]))
+	}
+	assert.Equal(t, []string{"a0", "b2", "c3", "a4", "<nil> 5"}, kept)
+}
+
+func Test_Enter(t *testing.T) {
+	clock := newFakeClock()
+	opts, path := newTestOptions(t)
+	opts.Level = "debug"
+	opts.Clock = clock
+	logger := log.New(opts)
+
+	func() {
+		defer logger.Enter("handleRequest", log.String("id", "42"))()
+		clock.Advance(1500 * time.Millisecond)
+	}()
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "entering handleRequest", entries[0]["message"])
+	assert.Equal(t, "42", entries[0]["id"])
+	assert.Nil(t, entries[0]["elapsed"])
+	assert.Equal(t, "exiting handleRequest", entries[1]["message"])
+	assert.Equal(t, "42", entries[1]["id"])
+	assert.Equal(t, float64(1500), entries[1]["elapsed"])
+	assert.Contains(t, entries[1]["caller"], "log_test.go")
+}
+
+func Test_AutoFlushOnExit(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.BufferSize = 1 << 16
+	logger := log.New(opts)
+	logger.AutoFlushOnExit()
+
+	logger.Info("buffered")
+	assert.Empty(t, readEntries(t, path))
+
+	log.Shutdown()
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "buffered", entries[0]["message"])
+
+	// The registry is emptied, shutting down again is a no-op.
+	log.Shutdown()
+}
+
+func Test_Int64AsString(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.Int64AsString = true
+	logger := log.New(opts).WithValues("tenant_id", int64(1<<62))
+
+	logger.Info("large ids", log.Int64("id", 9007199254740993), log.Uint64("uid", 1<<63), log.Int32("small", 7))
+	logger.Flush()
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"id":"9007199254740993"`)
+	assert.Contains(t, string(data), `"uid":"9223372036854775808"`)
+	assert.Contains(t, string(data), `"tenant_id":"4611686018427387904"`)
+	assert.Contains(t, string(data), `"small":7`)
+}
+
+func Test_ConsoleWrap(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.Format = "console"
+	opts.ConsoleWrap = 20
+	logger := log.New(opts)
+
+	logger.Info("the quick brown fox jumps over the lazy dog")
+	logger.Info("short message")
+	logger.Flush()
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	assert.Len(t, lines, 4)
+	assert.True(t, strings.HasSuffix(lines[0], "\tthe quick brown fox"), lines[0])
+	assert.Equal(t, "    jumps over the", lines[1])
+	assert.Equal(t, "    lazy dog", lines[2])
+	assert.True(t, strings.HasSuffix(lines[3], "\tshort message"), lines[3])
+	for i, line := range lines[:3] {
+		if i == 0 {
+			line = line[strings.LastIndex(line, "\t")+1:]
+		}
+		assert.LessOrEqual(t, utf8.RuneCountInString(line), 20, line)
+	}
+
+	opts, path = newTestOptions(t)
+	opts.ConsoleWrap = 20
+	logger = log.New(opts)
+	logger.Info("the quick brown fox jumps over the lazy dog")
+	logger.Flush()
+	assert.Equal(t, "the quick brown fox jumps over the lazy dog", readEntries(t, path)[0]["message"])
+}
+
+func Test_RegisterEncoder(t *testing.T) {
+	log.RegisterEncoder("short-json", func(cfg zapcore.EncoderConfig) zapcore.Encoder {
+		cfg.MessageKey = "msg"
+		cfg.TimeKey = ""
+
+		return zapcore.NewJSONEncoder(cfg)
+	})
+	opts, path := newTestOptions(t)
+	opts.Format = "short-json"
+	assert.Empty(t, opts.Validate())
+	logger := log.New(opts)
+
+	logger.Info("custom encoder", log.String("key", "value"))
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "custom encoder", entries[0]["msg"])
+	assert.Equal(t, "value", entries[0]["key"])
+	assert.Nil(t, entries[0]["timestamp"])
+
+	opts.Format = "unregistered"
+	assert.NotEmpty(t, opts.Validate())
+	assert.Panics(t, func() { log.RegisterEncoder("json", nil) })
+}
+
+func Test_SampleAnnotation(t *testing.T) {
+	clock := newFakeClock()
+	opts, path := newTestOptions(t)
+	opts.Clock = clock
+	opts.SampleAnnotation = true
+	logger := log.New(opts).WithValues("key", "value")
+
+	for i := 0; i < 300; i++ {
+		logger.Info("same message", log.Int("i", i))
+	}
+	clock.Advance(2 * time.Second)
+	logger.Info("same message", log.Int("i", 300))
+	logger.Flush()
+
+	var annotated []int
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 103)
+	for _, entry := range entries {
+		assert.Equal(t, "value", entry["key"])
+		if entry["sampled"] == true {
+			assert.Equal(t, float64(100), entry["sample_rate"])
+			annotated = append(annotated, int(entry["i"].(float64)))
+		} else {
+			assert.Nil(t, entry["sample_rate"])
+		}
+	}
+	assert.Equal(t, []int{199, 299}, annotated)
+}
+
+func Test_Throttle(t *testing.T) {
+	clock := newFakeClock()
+	opts, path := newTestOptions(t)
+	opts.Clock = clock
+	logger := log.New(opts).Throttle(3 * time.Second)
+
+	for i := 0; i < 5; i++ {
+		logger.Info("health check", log.Int("i", i))
+		clock.Advance(time.Second)
+	}
+	logger.Info("other caller", log.Int("i", 5))
+	logger.Flush()
+
+	var kept []string
+	for _, entry := range readEntries(t, path) {
+		kept = append(kept, fmt.Sprint(entry["message"], entry["i"]))
+	}
+	assert.Equal(t, []string{"health check0", "health check3", "other caller5"}, kept)
+}
+
+func Test_RuntimeFields(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.RuntimeFields = true
+	logger := log.New(opts)
+
+	logger.Info("with runtime fields")
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, runtime.Version(), entries[0]["go_version"])
+	assert.Equal(t, runtime.GOOS, entries[0]["goos"])
+	assert.Equal(t, runtime.GOARCH, entries[0]["goarch"])
+	assert.Equal(t, float64(runtime.NumCPU()), entries[0]["num_cpu"])
+}
+
+func Test_KeyTransform(t *testing.T) {
+	for key, want := range map[string]string{
+		"userID":     "user_id",
+		"userId":     "user_id",
+		"HTTPServer": "http_server",
+		"user_id":    "user_id",
+		"retry2Max":  "retry2_max",
+	} {
+		assert.Equal(t, want, log.SnakeCase(key), key)
+	}
+
+	opts, path := newTestOptions(t)
+	opts.KeyTransform = strings.ToUpper
+	logger := log.New(opts)
+
+	logger.WithValues("userID", 42).Info("transformed")
+	logger.WithValuesBatch([]interface{}{"requestID", "abc"}).Info("batch")
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, float64(42), entries[0]["USERID"])
+	assert.Equal(t, "abc", entries[1]["REQUESTID"])
+
+	opts, path = newTestOptions(t)
+	opts.KeyTransform = log.SnakeCase
+	logger = log.New(opts)
+	logger.WithValues("userID", 42).Info("snake case")
+	logger.Flush()
+	entries = readEntries(t, path)
+	assert.Equal(t, float64(42), entries[0]["user_id"])
+	assert.Equal(t, "snake case", entries[0]["message"])
+}
+
+func Test_AddOutputPathIf(t *testing.T) {
+	dir := t.TempDir()
+	devPath, prodPath := filepath.Join(dir, "dev.log"), filepath.Join(dir, "prod.log")
+	sinkPath := filepath.Join(dir, "errors.log")
+
+	opts := log.NewOptions().
+		AddOutputPathIf(false, devPath).
+		AddOutputPathIf(true, prodPath).
+		AddSinkIf(false, log.SinkOptions{Path: sinkPath, Level: "error"})
+	opts.Format = "json"
+	assert.Equal(t, []string{"stdout", prodPath}, opts.OutputPaths)
+	assert.Empty(t, opts.Sinks)
+
+	logger := log.New(opts)
+	logger.Error("conditional outputs")
+	logger.Flush()
+
+	assert.Len(t, readEntries(t, prodPath), 1)
+	_, err := os.Stat(devPath)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(sinkPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_Startup(t *testing.T) {
+	opts, path := newTestOptions(t)
+	logger := log.New(opts)
+
+	logger.Startup(map[string]interface{}{"version": "1.2.3", "config": "prod"})
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "startup", entries[0]["message"])
+	assert.Equal(t, "INFO", entries[0]["level"])
+	assert.Equal(t, "1.2.3", entries[0]["version"])
+	assert.Equal(t, "prod", entries[0]["config"])
+	assert.Equal(t, runtime.Version(), entries[0]["go_version"])
+	assert.Equal(t, float64(os.Getpid()), entries[0]["pid"])
+	assert.NotEmpty(t, entries[0]["host"])
+}
+
+type traceSampledKey struct{}
+
+func Test_TraceSampled(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.TraceSampled = func(ctx context.Context) bool {
+		sampled, _ := ctx.Value(traceSampledKey{}).(bool)
+
+		return sampled
+	}
+	logger := log.New(opts).WithValues("service", "api")
+
+	sampled := logger.WithContext(context.WithValue(context.Background(), traceSampledKey{}, true))
+	unsampled := logger.WithContext(context.WithValue(context.Background(), traceSampledKey{}, false))
+	log.FromContext(sampled).Debug("sampled debug")
+	log.FromContext(sampled).Info("sampled info")
+	log.FromContext(unsampled).Debug("unsampled debug")
+	log.FromContext(unsampled).Info("unsampled info")
+	logger.Debug("plain debug")
+	logger.Flush()
+
+	var messages []string
+	for _, entry := range readEntries(t, path) {
+		assert.Equal(t, "api", entry["service"])
+		messages = append(messages, entry["message"].(string))
+	}
+	assert.Equal(t, []string{"sampled debug", "sampled info", "unsampled info"}, messages)
+}
+
+func Test_SchemaVersion(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.SchemaVersion = "2"
+	logger := log.New(opts)
+
+	logger.Info("parent")
+	logger.WithName("child").WithValues("key", "value").Info("child")
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	for _, entry := range entries {
+		assert.Equal(t, "2", entry["schema_version"], entry["message"])
+	}
+}
+
+func Test_InitConcurrent(t *testing.T) {
+	opts, path := newTestOptions(t)
+	defer log.Init(log.NewOptions())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				log.WithValues("j", j).Info("concurrent")
+				log.V(0).Info("concurrent")
+			}
+		}()
+	}
+	log.Init(opts)
+	wg.Wait()
+	log.Info("after init")
+	log.Flush()
+
+	entries := readEntries(t, path)
+	assert.NotEmpty(t, entries)
+	assert.Equal(t, "after init", entries[len(entries)-1]["message"])
+}
+
+func Test_Verbosity(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.Verbosity = 2
+	logger := log.New(opts)
+
+	for v := 0; v < 5; v++ {
+		logger.V(log.Level(v)).Infow("verbose", "v", v)
+	}
+	assert.False(t, logger.V(3).Enabled())
+	logger.Flush()
+
+	var written []interface{}
+	for _, entry := range readEntries(t, path) {
+		assert.Equal(t, "INFO", entry["level"])
+		written = append(written, entry["v"])
+	}
+	assert.Equal(t, []interface{}{float64(0), float64(1), float64(2)}, written)
+
+	opts.Verbosity = -1
+	assert.NotEmpty(t, opts.Validate())
+}
+
+func Test_VerbosityZero(t *testing.T) {
+	opts, path := newTestOptions(t)
+	logger := log.New(opts)
+
+	logger.V(log.DebugLevel).Info("debug")
+	logger.V(0).Info("info")
+	logger.V(1).Info("warn")
+	assert.False(t, logger.V(log.DebugLevel).Enabled())
+	logger.Flush()
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "INFO", entries[0]["level"])
+	assert.Equal(t, "WARN", entries[1]["level"])
+}
+
+func Test_FieldFuncs(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.AuditOutputPath = filepath.Join(t.TempDir(), "audit.log")
+	var calls int
+	opts.FieldFuncs = map[string]func() interface{}{
+		"container_id": func() interface{} {
+			calls++
+
+			return "abc123"
+		},
+		"cgroup":  func() interface{} { return errors.New("no cgroup") },
+		"panicky": func() interface{} { panic("boom") },
+	}
+	logger := log.New(opts)
+
+	logger.Info("first")
+	logger.WithValues("key", "value").Info("second")
+	logger.Audit().Info("audited")
+	logger.Flush()
+
+	assert.Equal(t, 1, calls)
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	for _, entry := range entries {
+		assert.Equal(t, "abc123", entry["container_id"])
+		assert.Equal(t, "error: no cgroup", entry["cgroup"])
+		assert.Equal(t, "panic: boom", entry["panicky"])
+	}
+	assert.Equal(t, "abc123", readEntries(t, opts.AuditOutputPath)[0]["container_id"])
+}
+
+func Test_CollapseConsecutive(t *testing.T) {
+	opts, path := newTestOptions(t)
+	opts.CollapseConsecutive = true
+	logger := log.New(opts)
+	child := logger.WithName("worker").WithValues("key", "value")
+
+	for i := 0; i < 4; i++ {
+		logger.Warn("retrying", log.String("host", "db"))
+	}
+	logger.Warn("retrying", log.String("host", "cache"))
+	child.Info("child")
+	child.Info("child")
+	logger.Info("done")
+	logger.Info("done")
+	logger.Flush()
+
+	var lines []string
+	entries := readEntries(t, path)
+	for _, entry := range entries {
+		lines = append(lines, fmt.Sprintf("%v %v %v %v", entry["level"], entry["message"], entry["host"], entry["key"]))
+	}
+	assert.Equal(t, []string{
+		"WARN retrying db <nil>",
+		"WARN (repeated 3 times) db <nil>",
+		"WARN retrying cache <nil>",
+		"INFO child <nil> value",
+		"INFO (repeated 1 times) <nil> value",
+		"INFO done <nil> <nil>",
+		"INFO (repeated 1 times) <nil> <nil>",
+	}, lines)
+	// the summary is attributed to the collapsed line
+	assert.Equal(t, "worker", entries[4]["logger"])
+	assert.Equal(t, entries[3]["caller"], entries[4]["caller"])
+	assert.NotEmpty(t, entries[4]["caller"])
+	assert.Equal(t, uint64(3), logger.Stats().Deduped["warn"])
+	assert.Equal(t, uint64(2), logger.Stats().Deduped["info"])
+}
+
+func Test_ThrottleSampled(t *testing.T) {
+	clock := newFakeClock()
+	opts, path := newTestOptions(t)
+	opts.Clock = clock
+	opts.SampleAnnotation = true
+	base := log.New(opts)
+	logger := base.Throttle(time.Nanosecond)
+
+	for i := 0; i < 300; i++ {
+		logger.Info("throttled", log.Int("i", i))
+		clock.Advance(time.Millisecond)
+	}
+	logger.Flush()
+
+	var annotated []int
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 102)
+	for _, entry := range entries {
+		if entry["sampled"] == true {
+			annotated = append(annotated, int(entry["i"].(float64)))
+		}
+	}
+	assert.Equal(t, []int{199, 299}, annotated)
+	assert.Equal(t, uint64(198), base.Stats().SampledOut["info"])
+}
+
+func Test_PauseResume(t *testing.T) {
+	opts, path := newTestOptions(t)
+	logger := log.New(opts)
+	child := logger.WithValues("key", "value")
+
+	logger.Info("before pause")
+	logger.Pause()
+	logger.Info("while paused")
+	child.Error("child while paused")
+	logger.Resume()
+	child.Info("after resume")
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "before pause", entries[0]["message"])
+	assert.Equal(t, "after resume", entries[1]["message"])
+}
+
+// newBenchmarkLogger returns an unsampled logger discarding its output.
+func newBenchmarkLogger() log.Logger {
+	enc := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	core := zapcore.NewCore(enc, zapcore.AddSync(io.Discard), zapcore.DebugLevel)
+
+	return log.NewLogger(zap.New(core))
+}
+
+// newBenchmarkOptionsLogger returns a logger built from the options with
+// SuppressRepeatedContext, discarding its output. Sampling is disabled, for
+// the entries to reach the cores.
+func newBenchmarkOptionsLogger() log.Logger {
+	opts := log.NewOptions()
+	opts.Format = "json"
+	opts.OutputPaths = nil
+	opts.Writer = io.Discard
+	opts.NoSampleAbove = "debug"
+	opts.SuppressRepeatedContext = true
+
+	return log.New(opts)
+}
+
+func BenchmarkInfoNoFields(b *testing.B) {
+	logger := newBenchmarkOptionsLogger()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.Info("Hello world!")
+	}
+}
+
+func BenchmarkInfofNoArgs(b *testing.B) {
+	logger := newBenchmarkOptionsLogger()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.Infof("Hello world!")
+	}
+}
+
+func BenchmarkInfofArgs(b *testing.B) {
+	logger := newBenchmarkLogger()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.Infof("Hello %s!", "world")
+	}
+}
+
+func BenchmarkWithValuesChained(b *testing.B) {
+	logger := newBenchmarkLogger()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.WithValues("request_id", "r-1").
+			WithValues("user", "alice").
+			WithValues("method", "GET", "path", "/").
+			Info("Hello world!")
+	}
+}
+
+func BenchmarkWithValuesBatch(b *testing.B) {
+	logger := newBenchmarkLogger()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.WithValuesBatch(
+			[]interface{}{"request_id", "r-1"},
+			[]interface{}{"user", "alice"},
+			[]interface{}{"method", "GET", "path", "/"},
+		).Info("Hello world!")
+	}
 }
@@ -0,0 +1,60 @@
+package log
+
+import (
+	"go.uber.org/zap"
+)
+
+const (
+	metricMessage = "metric"
+
+	untypedMetric = "untyped"
+	counterMetric = "counter"
+	gaugeMetric   = "gauge"
+)
+
+// Metric logs an untyped metric with the std logger.
+func Metric(name string, value float64, tags map[string]string) {
+	std().zapLogger.Info(metricMessage, metricFields(untypedMetric, name, value, tags)...)
+}
+
+// Counter logs a counter metric with the std logger.
+func Counter(name string, value float64, tags map[string]string) {
+	std().zapLogger.Info(metricMessage, metricFields(counterMetric, name, value, tags)...)
+}
+
+// Gauge logs a gauge metric with the std logger.
+func Gauge(name string, value float64, tags map[string]string) {
+	std().zapLogger.Info(metricMessage, metricFields(gaugeMetric, name, value, tags)...)
+}
+
+// Metric logs a metric as an info level entry with the "metric" message and
+// name, value, tags and type fields, for log based metrics pipelines to
+// extract. Its type is "untyped", Counter and Gauge log typed metrics. As
+// they share their message, metrics beyond 100 per second are sampled like
+// any other entry.
+func (l *zapLogger) Metric(name string, value float64, tags map[string]string) {
+	l.zapLogger.Info(metricMessage, metricFields(untypedMetric, name, value, tags)...)
+}
+
+// Counter logs a metric of the "counter" type, see Metric.
+func (l *zapLogger) Counter(name string, value float64, tags map[string]string) {
+	l.zapLogger.Info(metricMessage, metricFields(counterMetric, name, value, tags)...)
+}
+
+// Gauge logs a metric of the "gauge" type, see Metric.
+func (l *zapLogger) Gauge(name string, value float64, tags map[string]string) {
+	l.zapLogger.Info(metricMessage, metricFields(gaugeMetric, name, value, tags)...)
+}
+
+func metricFields(typ, name string, value float64, tags map[string]string) []zap.Field {
+	if tags == nil {
+		tags = map[string]string{}
+	}
+
+	return []zap.Field{
+		zap.String("name", name),
+		zap.Float64("value", value),
+		zap.Any("tags", tags),
+		zap.String("type", typ),
+	}
+}
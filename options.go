@@ -1,12 +1,15 @@
 package log
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/spf13/pflag"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"io"
 	"strings"
+	"time"
 )
 
 const (
@@ -17,6 +20,62 @@ const (
 	flagOutputPaths       = "log.output-paths"
 	flagDevelopment       = "log.development"
 	flagName              = "log.name"
+	flagDisableFatalExit  = "log.disable-fatal-exit"
+	flagDisablePanic      = "log.disable-panic"
+
+	flagSuppressRepeatedContext = "log.suppress-repeated-context"
+	flagSplitStdStreams         = "log.split-std-streams"
+	flagBufferSize              = "log.buffer-size"
+	flagSamplingReportInterval  = "log.sampling-report-interval"
+	flagShutdownMessage         = "log.shutdown-message"
+	flagFieldsFromEnv           = "log.fields-from-env"
+	flagMaxFields               = "log.max-fields"
+	flagFatalGracePeriod        = "log.fatal-grace-period"
+	flagCaptureFormatArgs       = "log.capture-format-args"
+	flagErrorCooldown           = "log.error-cooldown"
+	flagCallerEncoder           = "log.caller-encoder"
+	flagWriteTimeout            = "log.write-timeout"
+	flagConsoleMultiline        = "log.console-multiline"
+	flagPanicDiagnostics        = "log.panic-diagnostics"
+	flagUptimeField             = "log.uptime-field"
+	flagJSONSeq                 = "log.json-seq"
+	flagLogSizeAccounting       = "log.size-accounting"
+	flagWriteRetryAttempts      = "log.write-retry-attempts"
+	flagWriteRetryBackoff       = "log.write-retry-backoff"
+	flagMaxEntryBytes           = "log.max-entry-bytes"
+	flagAllowNoOutput           = "log.allow-no-output"
+	flagRingBufferSize          = "log.ring-buffer-size"
+	flagRawByteSizes            = "log.raw-byte-sizes"
+	flagNoSampleAbove           = "log.no-sample-above"
+	flagStacktraceDepth         = "log.stacktrace-depth"
+	flagMaskLongStrings         = "log.mask-long-strings"
+	flagPIIRedaction            = "log.pii-redaction"
+	flagPIIPatterns             = "log.pii-patterns"
+	flagEntrySeparator          = "log.entry-separator"
+	flagEntrySeparatorLevel     = "log.entry-separator-level"
+	flagFlagEvalLevel           = "log.flag-eval-level"
+	flagFallbackOutputPath      = "log.fallback-output-path"
+	flagRequestScopedSampling   = "log.request-scoped-sampling"
+	flagNumericLevelKey         = "log.numeric-level-key"
+	flagAuditOutputPath         = "log.audit-output-path"
+	flagEncodeErrorRecovery     = "log.encode-error-recovery"
+	flagTimeFormat              = "log.time-format"
+	flagIgnoreSyncErrors        = "log.ignore-sync-errors"
+	flagMaxFieldLength          = "log.max-field-length"
+	flagSinkQueueSize           = "log.sink-queue-size"
+	flagStructuredStacktrace    = "log.structured-stacktrace"
+	flagFirstThenSample         = "log.first-then-sample"
+	flagFormatMismatchCheck     = "log.format-mismatch-check"
+	flagSequenceNumbers         = "log.sequence-numbers"
+	flagSamplingKey             = "log.sampling-key"
+	flagSamplingKeyLRU          = "log.sampling-key-lru"
+	flagInt64AsString           = "log.int64-as-string"
+	flagConsoleWrap             = "log.console-wrap"
+	flagSampleAnnotation        = "log.sample-annotation"
+	flagRuntimeFields           = "log.runtime-fields"
+	flagSchemaVersion           = "log.schema-version"
+	flagVerbosity               = "log.verbosity"
+	flagCollapseConsecutive     = "log.collapse-consecutive"
 	// flagMaxBackups        = "log.max-backups"
 	// flagMaxAge            = "log.max-age"
 	// flagMaxSize           = "log.max-size"
@@ -25,17 +84,21 @@ const (
 
 	consoleFormat = "console"
 	jsonFormat    = "json"
+	// ecsFormat is JSON following the Elastic Common Schema.
+	ecsFormat = "ecs"
 )
 
 // Options 日志配置项.
 type Options struct {
-	OutputPaths       []string `json:"output-paths"       mapstructure:"output-paths"`       // 输出位置，例如 ["stdout", "/var/log/app.log"]
+	OutputPaths       []string `json:"output-paths"       mapstructure:"output-paths"`       // 输出位置，例如 ["stdout", "/var/log/app.log"]，也可以是带参数的 URL，例如 "file:///var/log/app.log?level=warn"，见 ParseOutputPath
 	Level             string   `json:"level"              mapstructure:"level"`              // 日志级别 debug/info/warn/error
-	Format            string   `json:"format"             mapstructure:"format"`             // 格式 json/console
+	Format            string   `json:"format"             mapstructure:"format"`             // 格式 json/console/ecs，ecs 为符合 Elastic Common Schema 的 json，也可以是通过 RegisterEncoder 注册的格式
 	DisableCaller     bool     `json:"enable-call"        mapstructure:"disable-call"`       // 是否启用 call
 	DisableStacktrace bool     `json:"disable-stacktrace" mapstructure:"disable-stacktrace"` // 是否记录 error 的 stack trace
 	Development       bool     `json:"development"        mapstructure:"development"`        // 是否 DPanic
 	ErrorOutputPaths  []string `json:"error-output-paths" mapstructure:"error-output-paths"` // 错误日志输出途径
+	DisableFatalExit  bool     `json:"disable-fatal-exit" mapstructure:"disable-fatal-exit"` // Fatal 写完日志后是否不再调用 os.Exit，用于测试
+	DisablePanic      bool     `json:"disable-panic"      mapstructure:"disable-panic"`      // Panic 写完日志后是否不再 panic，用于测试
 
 	// MaxSize        int           `json:"max-size"           mapstructure:"max-size"`        // 文件最大 MB(如果用了 lumberjack)
 	// MaxBackups     int           `json:"max-backups"        mapstructure:"max-backups"`     // 最大保留旧文件数
@@ -44,23 +107,284 @@ type Options struct {
 
 	Name string `json:"name"               mapstructure:"name"` // server Name
 
+	InitialFields map[string]interface{} `json:"initial-fields" mapstructure:"initial-fields"` // 每条日志都输出的固定字段，例如服务名、区域、实例 ID
+
+	Sinks []SinkOptions `json:"sinks" mapstructure:"sinks"` // 额外的输出，每个输出有各自的最低日志级别，可通过 Sinks() 构建
+
+	LevelColors     map[string]string     `json:"level-colors"     mapstructure:"level-colors"`     // console 格式下各级别的颜色，例如 {"error": "red", "warn": "yellow"}，未列出的级别使用默认颜色
+	LevelAliases    map[string]Level      `json:"level-aliases"    mapstructure:"level-aliases"`    // 级别的别名，例如 {"verbose": DebugLevel, "critical": FatalLevel}，配置中的所有级别都可以使用别名，不区分大小写
+	LevelFormats    map[string]string     `json:"level-formats"    mapstructure:"level-formats"`    // 各级别单独使用的输出格式，例如 {"error": "json"}，未列出的级别使用 Format
+	SampledMessages map[string]SampleRate `json:"sampled-messages" mapstructure:"sampled-messages"` // 按消息内容单独采样，key 为完整的日志消息，未列出的消息不受影响
+
+	SuppressRepeatedContext bool `json:"suppress-repeated-context" mapstructure:"suppress-repeated-context"` // 连续日志字段相同时是否只输出 "(same context)"
+	SplitStdStreams         bool `json:"split-std-streams"         mapstructure:"split-std-streams"`         // 输出到 stdout 时是否将 WARN 及以上级别的日志输出到 stderr
+	BufferSize              int  `json:"buffer-size"               mapstructure:"buffer-size"`               // 输出缓冲区大小(字节)，0 表示不缓冲，派生的日志器共享同一缓冲区
+
+	SamplingReportInterval time.Duration `json:"sampling-report-interval" mapstructure:"sampling-report-interval"` // 定期输出采样统计的间隔，0 表示不输出
+	ShutdownMessage        string        `json:"shutdown-message"         mapstructure:"shutdown-message"`         // Close 时在刷新前输出的 info 日志，为空表示不输出
+	FieldsFromEnv          string        `json:"fields-from-env"          mapstructure:"fields-from-env"`          // 环境变量前缀，带该前缀的环境变量会作为固定字段输出，key 为去掉前缀后的小写名称
+	MaxFields              int           `json:"max-fields"               mapstructure:"max-fields"`               // 单条日志最多输出的字段数，超出的字段被丢弃，0 表示不限制
+	FatalGracePeriod       time.Duration `json:"fatal-grace-period"       mapstructure:"fatal-grace-period"`       // Fatal 退出前等待所有输出刷新的最长时间
+	CaptureFormatArgs      bool          `json:"capture-format-args"      mapstructure:"capture-format-args"`      // Infof 等格式化方法是否同时将参数以 args 数组字段输出
+	ErrorCooldown          time.Duration `json:"error-cooldown"           mapstructure:"error-cooldown"`           // 相同消息的 error 日志在该时间内只输出一次，冷却结束后被抑制的条数随下一条相同日志输出，没有时单独输出一条带条数的日志
+	CallerEncoder          string        `json:"caller-encoder"           mapstructure:"caller-encoder"`           // 调用位置格式 short/full/twolevel
+	WriteTimeout           time.Duration `json:"write-timeout"            mapstructure:"write-timeout"`            // 写文件的超时时间，超时的日志被丢弃并报告到错误输出，0 表示不超时
+	ConsoleMultiline       bool          `json:"console-multiline"        mapstructure:"console-multiline"`        // console 格式下第一行输出消息，之后每个字段缩进单独一行，仅用于开发调试
+	PanicDiagnostics       bool          `json:"panic-diagnostics"        mapstructure:"panic-diagnostics"`        // Panic/Fatal 日志是否附带 goroutine 数量、内存统计，Fatal 还附带所有 goroutine 的堆栈，开销较大
+	UptimeField            bool          `json:"uptime-field"             mapstructure:"uptime-field"`             // 每条日志是否附带 uptime_ms 字段，即距日志器创建的毫秒数
+	JSONSeq                bool          `json:"json-seq"                 mapstructure:"json-seq"`                 // json 格式下是否在每条记录前加上 RS(0x1E) 字符，即 RFC 7464 JSON 文本序列
+	LogSizeAccounting      bool          `json:"size-accounting"          mapstructure:"size-accounting"`          // 是否按级别统计输出的字节数，通过 Stats 获取
+	WriteRetryAttempts     int           `json:"write-retry-attempts"     mapstructure:"write-retry-attempts"`     // 写输出失败时的最大重试次数，0 表示不重试
+	WriteRetryBackoff      time.Duration `json:"write-retry-backoff"      mapstructure:"write-retry-backoff"`      // 第一次重试前的等待时间，之后每次重试加倍
+	MaxEntryBytes          int           `json:"max-entry-bytes"          mapstructure:"max-entry-bytes"`          // 单条日志编码后的最大字节数，超出时只输出截断的消息和原大小，0 表示不限制
+	AllowNoOutput          bool          `json:"allow-no-output"          mapstructure:"allow-no-output"`          // 没有任何输出时是否创建一个丢弃所有日志的日志器，否则返回错误
+	RingBufferSize         int           `json:"ring-buffer-size"         mapstructure:"ring-buffer-size"`         // 在内存中保留的最近日志条数，通过 RecentLogs 获取，0 表示不保留
+	RawByteSizes           bool          `json:"raw-byte-sizes"           mapstructure:"raw-byte-sizes"`           // ByteSize 字段是否同时以 <key>_bytes 字段输出原始字节数
+	NoSampleAbove          string        `json:"no-sample-above"          mapstructure:"no-sample-above"`          // 该级别及以上的日志不参与采样，例如 error，为空表示所有级别都参与采样
+	StacktraceDepth        int           `json:"stacktrace-depth"         mapstructure:"stacktrace-depth"`         // stack trace 最多保留的栈帧数，其余的以 "...N more" 代替，0 表示不限制
+	MaskLongStrings        int           `json:"mask-long-strings"        mapstructure:"mask-long-strings"`        // 字符串字段超过该长度时只保留首尾各 4 个字符，中间以 "…" 代替，0 表示不处理
+	PIIRedaction           bool          `json:"pii-redaction"            mapstructure:"pii-redaction"`            // 是否将字符串字段中疑似个人信息的内容替换为 [PII]，开销较大
+	PIIPatterns            []string      `json:"pii-patterns"             mapstructure:"pii-patterns"`             // 识别个人信息的正则表达式，为空时使用 DefaultPIIPatterns
+	EntrySeparator         string        `json:"entry-separator"          mapstructure:"entry-separator"`          // console 格式下追加在日志之后的分隔内容，例如 "\n" 输出一个空行，为空表示不分隔
+	EntrySeparatorLevel    string        `json:"entry-separator-level"    mapstructure:"entry-separator-level"`    // 追加分隔内容的最低日志级别，为空表示所有级别
+	FlagEvalLevel          string        `json:"flag-eval-level"          mapstructure:"flag-eval-level"`          // FlagEval 输出特性开关求值日志的级别，为空表示 debug
+	FallbackOutputPath     string        `json:"fallback-output-path"     mapstructure:"fallback-output-path"`     // 输出写入失败时改为写入的位置，例如 stderr，为空表示不转写
+	RequestScopedSampling  int           `json:"request-scoped-sampling"  mapstructure:"request-scoped-sampling"`  // 通过 WithContext 保存到 context 中的日志器，每条相同消息最多输出的条数，计数随 context 独立，0 表示不限制
+	NumericLevelKey        string        `json:"numeric-level-key"        mapstructure:"numeric-level-key"`        // 以整数输出日志级别的字段名，debug 为 -1，info 为 0，依次递增，为空表示不输出
+	AuditOutputPath        string        `json:"audit-output-path"        mapstructure:"audit-output-path"`        // Audit() 日志器写入的文件，不采样、不缓冲，每条日志写入后立即同步，为空表示不单独输出审计日志
+	EncodeErrorRecovery    bool          `json:"encode-error-recovery"    mapstructure:"encode-error-recovery"`    // 是否预先编码对象、数组等字段，编码失败或 panic 的字段替换为 "encode_error"，错误写入 error-output-paths，开销较大
+	TimeFormat             string        `json:"time-format"              mapstructure:"time-format"`              // 时间字段的格式，Go 时间布局如 time.RFC3339Nano，或 epochnano 输出 Unix 纳秒整数，为空时使用 2006-01-02 15:04:05.000
+	IgnoreSyncErrors       []string      `json:"ignore-sync-errors"       mapstructure:"ignore-sync-errors"`       // Sync 忽略的错误的正则表达式，默认为 DefaultIgnoreSyncErrors，即 Linux 下同步 stdout 的无害错误
+	MaxFieldLength         int           `json:"max-field-length"         mapstructure:"max-field-length"`         // 字符串字段的最大字节数，超出的部分被截断，JSON 对象或数组在成员边界截断并保持合法，0 表示不限制
+	SinkQueueSize          int           `json:"sink-queue-size"          mapstructure:"sink-queue-size"`          // 网络输出（tcp://）各自异步写入队列的长度，避免拖慢其他输出，队列满时丢弃日志，0 表示同步写入
+	StructuredStacktrace   bool          `json:"structured-stacktrace"    mapstructure:"structured-stacktrace"`    // json 格式下是否将 stacktrace 输出为 {function, file, line} 对象的数组，而不是多行字符串
+	FirstThenSample        int           `json:"first-then-sample"        mapstructure:"first-then-sample"`        // 每个调用位置每秒最多输出的日志条数，每个调用位置的第一条日志总是输出，0 表示不限制
+	FormatMismatchCheck    bool          `json:"format-mismatch-check"    mapstructure:"format-mismatch-check"`    // Infof 等方法的格式与参数个数不符时，是否原样输出格式并附带 format_mismatch 字段和参数，而不是输出 %! 错误
+	SequenceNumbers        bool          `json:"sequence-numbers"         mapstructure:"sequence-numbers"`         // 是否为每条日志附带从 1 开始连续递增的 seq 字段，派生的日志器共享同一序列，用于发现丢失或乱序的日志
+	SamplingKey            string        `json:"sampling-key"             mapstructure:"sampling-key"`             // 按该字段的值单独采样，例如 tenant，每个值使用独立的 SamplingKeyRate 计数，不带该字段的日志不受影响，为空表示不按字段采样
+	SamplingKeyRate        SampleRate    `json:"sampling-key-rate"        mapstructure:"sampling-key-rate"`        // SamplingKey 每个值的采样频率
+	SamplingKeyLRU         int           `json:"sampling-key-lru"         mapstructure:"sampling-key-lru"`         // SamplingKey 最多保留计数的值的个数，超出时淘汰最久未使用的值，被淘汰的值重新从 First 开始计数，0 表示 1024
+	Int64AsString          bool          `json:"int64-as-string"          mapstructure:"int64-as-string"`          // json 格式下是否将 int64 和 uint64 字段输出为字符串，避免 JavaScript 读取较大的 ID 时丢失精度
+	ConsoleWrap            int           `json:"console-wrap"             mapstructure:"console-wrap"`             // console 格式下消息超过该宽度时换行，后续行缩进，输出到 stdout/stderr 但不是终端（重定向或管道）时不换行，0 表示不换行
+	SampleAnnotation       bool          `json:"sample-annotation"        mapstructure:"sample-annotation"`        // 采样生效后保留的日志是否附带 "sampled":true 和 "sample_rate":N，N 为每条日志代表的条数，用于推算实际的日志量
+	RuntimeFields          bool          `json:"runtime-fields"           mapstructure:"runtime-fields"`           // 是否为每条日志附带 go_version、goos、goarch 和 num_cpu 字段，在创建日志器时计算一次
+	SchemaVersion          string        `json:"schema-version"           mapstructure:"schema-version"`           // 附带在每条日志上的 schema_version 字段的值，便于日志处理流程在 schema 迁移期间区分版本，为空表示不附带
+	Verbosity              int           `json:"verbosity"                mapstructure:"verbosity"`                // V(n) 输出日志的最大 n，V(n) 在 n 大于该值时不输出；默认为 0，此时 V(n) 保持之前的行为，以级别 n 输出
+	CollapseConsecutive    bool          `json:"collapse-consecutive"     mapstructure:"collapse-consecutive"`     // 是否像 uniq 一样合并连续相同的日志（级别、消息和字段都相同），出现不同的日志时输出 "(repeated N times)"
+
+	// PanicValue 决定 Panic 写完日志后 panic 的值，为空时使用日志消息
+	PanicValue func(msg string, fields []Field) interface{} `json:"-" mapstructure:"-"`
+	// Clock 日志使用的时钟，为空时使用系统时钟，主要用于测试，固定的时钟下采样保留的日志也是确定的
+	Clock zapcore.Clock `json:"-" mapstructure:"-"`
+	// ContextKey WithContext 保存日志器时使用的 context key，为空时使用包内默认的 key。
+	// 与 context.WithValue 的要求一样，应使用自定义的未导出类型作为 key，避免与其他包冲突；
+	// 多个日志器使用不同的 key 即可同时保存在同一个 context 中，使用 FromContextWithKey 取出。
+	ContextKey interface{} `json:"-" mapstructure:"-"`
+	// Writer 除 OutputPaths 外的额外输出
+	Writer io.Writer `json:"-" mapstructure:"-"`
+	// LevelEnabler 自定义日志级别过滤，设置后取代 Level。每条日志都会调用，
+	// 可能被并发调用，需要保证并发安全
+	LevelEnabler func(Level) bool `json:"-" mapstructure:"-"`
+	// KeyTransform 转换 WithValues 和 WithValuesBatch 传入的字段名，例如 SnakeCase 将 userID 转换为 user_id，
+	// timestamp、level、message 等标准字段名不会被转换；为空表示不转换
+	KeyTransform func(string) string `json:"-" mapstructure:"-"`
+	// FieldFuncs 创建日志器时调用一次的函数，返回值作为对应 key 的字段附带在每条日志上，例如读取容器 ID；
+	// 返回 error 或 panic 时字段的值为错误信息，不影响日志器的创建
+	FieldFuncs map[string]func() interface{} `json:"-" mapstructure:"-"`
+	// TraceSampled 判断 ctx 中的 trace 是否被采样，例如 trace.SpanContextFromContext(ctx).IsSampled()，
+	// 被采样的请求通过 WithContext 保存到 context 中的日志器输出所有级别的日志，包括 debug；为空表示不区分
+	TraceSampled func(ctx context.Context) bool `json:"-" mapstructure:"-"`
+
 	// EnableColor bool `json:"enable-color"       mapstructure:"enable-color"`
 }
 
+// ParseLevel parses a level with the LevelAliases of the std logger.
+func ParseLevel(text string) (Level, error) { return std().opts.ParseLevel(text) }
+
+// ParseLevel parses a level name, such as "info" or "WARN", or one of the
+// LevelAliases, ignoring case. Every level of the options is parsed with it.
+func (o *Options) ParseLevel(text string) (Level, error) {
+	for alias, lvl := range o.LevelAliases {
+		if strings.EqualFold(alias, text) {
+			return lvl, nil
+		}
+	}
+
+	return zapcore.ParseLevel(text)
+}
+
 // Validate 验证配置是否符合规范.
 func (o *Options) Validate() []error {
 	var errs []error
 
-	var zapLevel zapcore.Level
-	if err := zapLevel.UnmarshalText([]byte(o.Level)); err != nil {
+	if _, err := o.ParseLevel(o.Level); err != nil {
 		errs = append(errs, err)
 	}
 
+	if o.BufferSize < 0 {
+		errs = append(errs, fmt.Errorf("not a valid log buffer size: %d", o.BufferSize))
+	}
+
+	if o.FirstThenSample < 0 {
+		errs = append(errs, fmt.Errorf("not a valid first then sample rate: %d", o.FirstThenSample))
+	}
+
+	if o.SinkQueueSize < 0 {
+		errs = append(errs, fmt.Errorf("not a valid sink queue size: %d", o.SinkQueueSize))
+	}
+
+	if o.MaxFieldLength < 0 {
+		errs = append(errs, fmt.Errorf("not a valid max field length: %d", o.MaxFieldLength))
+	}
+
+	if o.MaxFields < 0 {
+		errs = append(errs, fmt.Errorf("not a valid log max fields: %d", o.MaxFields))
+	}
+
+	if o.FatalGracePeriod < 0 {
+		errs = append(errs, fmt.Errorf("not a valid fatal grace period: %s", o.FatalGracePeriod))
+	}
+
+	if o.ErrorCooldown < 0 {
+		errs = append(errs, fmt.Errorf("not a valid error cooldown: %s", o.ErrorCooldown))
+	}
+
+	if o.WriteTimeout < 0 {
+		errs = append(errs, fmt.Errorf("not a valid write timeout: %s", o.WriteTimeout))
+	}
+
+	if o.SamplingReportInterval < 0 {
+		errs = append(errs, fmt.Errorf("not a valid sampling report interval: %s", o.SamplingReportInterval))
+	}
+
 	format := strings.ToLower(o.Format)
-	if format != consoleFormat && format != jsonFormat {
+	if !validFormat(format) {
 		errs = append(errs, fmt.Errorf("not a valid log format: %q", o.Format))
 	}
 
+	switch o.CallerEncoder {
+	case "", shortCallerEncoder, fullCallerEncoder, twoLevelCallerEncoder:
+	default:
+		errs = append(errs, fmt.Errorf("not a valid caller encoder: %q", o.CallerEncoder))
+	}
+
+	if o.TimeFormat != "" && o.TimeFormat != epochNanoTimeFormat && !validTimeLayout(o.TimeFormat) {
+		errs = append(errs, fmt.Errorf("not a valid time format: %q", o.TimeFormat))
+	}
+
+	for _, sink := range o.Sinks {
+		errs = append(errs, sink.validate(o)...)
+	}
+
+	for _, path := range o.OutputPaths {
+		cfg, err := ParseOutputPath(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("not a valid output path: %w", err))
+
+			continue
+		}
+		if cfg.Level != "" {
+			if _, err := o.ParseLevel(cfg.Level); err != nil {
+				errs = append(errs, fmt.Errorf("not a valid level for output path %s: %q", path, cfg.Level))
+			}
+		}
+	}
+
+	if len(o.OutputPaths) == 0 && len(o.Sinks) == 0 && o.Writer == nil && !o.AllowNoOutput {
+		errs = append(errs, errNoOutput)
+	}
+
+	if o.NoSampleAbove != "" {
+		if _, err := o.ParseLevel(o.NoSampleAbove); err != nil {
+			errs = append(errs, fmt.Errorf("not a valid no sample above level: %q", o.NoSampleAbove))
+		}
+	}
+
+	if o.EntrySeparatorLevel != "" {
+		if _, err := o.ParseLevel(o.EntrySeparatorLevel); err != nil {
+			errs = append(errs, fmt.Errorf("not a valid entry separator level: %q", o.EntrySeparatorLevel))
+		}
+	}
+
+	if o.FlagEvalLevel != "" {
+		if _, err := o.ParseLevel(o.FlagEvalLevel); err != nil {
+			errs = append(errs, fmt.Errorf("not a valid flag eval level: %q", o.FlagEvalLevel))
+		}
+	}
+
+	if _, err := o.piiPatterns(); err != nil {
+		errs = append(errs, fmt.Errorf("not a valid pii pattern: %w", err))
+	}
+
+	if _, err := compilePatterns(o.IgnoreSyncErrors); err != nil {
+		errs = append(errs, fmt.Errorf("not a valid ignore sync errors pattern: %w", err))
+	}
+
+	if o.MaskLongStrings != 0 && o.MaskLongStrings < 2*maskedStringEnds {
+		errs = append(errs, fmt.Errorf("not a valid mask long strings threshold, must be at least %d: %d", 2*maskedStringEnds, o.MaskLongStrings))
+	}
+
+	if o.StacktraceDepth < 0 {
+		errs = append(errs, fmt.Errorf("not a valid stacktrace depth: %d", o.StacktraceDepth))
+	}
+
+	if o.RequestScopedSampling < 0 {
+		errs = append(errs, fmt.Errorf("not a valid request scoped sampling: %d", o.RequestScopedSampling))
+	}
+
+	if o.RingBufferSize < 0 {
+		errs = append(errs, fmt.Errorf("not a valid ring buffer size: %d", o.RingBufferSize))
+	}
+
+	if o.MaxEntryBytes < 0 {
+		errs = append(errs, fmt.Errorf("not a valid max entry bytes: %d", o.MaxEntryBytes))
+	}
+
+	if o.WriteRetryAttempts < 0 || o.WriteRetryBackoff < 0 {
+		errs = append(errs, fmt.Errorf("not a valid write retry: %d attempts, %s backoff", o.WriteRetryAttempts, o.WriteRetryBackoff))
+	}
+
+	if o.JSONSeq && o.Format != jsonFormat {
+		errs = append(errs, fmt.Errorf("json sequence requires the json format, got %q", o.Format))
+	}
+
+	for name, format := range o.LevelFormats {
+		if _, err := o.ParseLevel(name); err != nil {
+			errs = append(errs, fmt.Errorf("not a valid level in level formats: %q", name))
+		}
+		if !validFormat(format) {
+			errs = append(errs, fmt.Errorf("not a valid log format for level %s: %q", name, format))
+		}
+	}
+
+	for name, color := range o.LevelColors {
+		if _, err := o.ParseLevel(name); err != nil {
+			errs = append(errs, fmt.Errorf("not a valid level in level colors: %q", name))
+		}
+		if _, ok := colorCodes[strings.ToLower(color)]; !ok {
+			errs = append(errs, fmt.Errorf("not a valid color for level %s: %q", name, color))
+		}
+	}
+
+	if o.Verbosity < 0 {
+		errs = append(errs, fmt.Errorf("not a valid verbosity: %d", o.Verbosity))
+	}
+	if o.ConsoleWrap < 0 {
+		errs = append(errs, fmt.Errorf("not a valid console wrap width: %d", o.ConsoleWrap))
+	}
+	if o.SamplingKeyLRU < 0 {
+		errs = append(errs, fmt.Errorf("not a valid sampling key lru: %d", o.SamplingKeyLRU))
+	}
+	if rate := o.SamplingKeyRate; o.SamplingKey != "" && (rate.Tick < 0 || rate.First < 0 || rate.Thereafter < 0) {
+		errs = append(errs, fmt.Errorf("not a valid sample rate for sampling key %q: %+v", o.SamplingKey, rate))
+	}
+
+	for msg, rate := range o.SampledMessages {
+		if rate.Tick < 0 || rate.First < 0 || rate.Thereafter < 0 {
+			errs = append(errs, fmt.Errorf("not a valid sample rate for message %q: %+v", msg, rate))
+		}
+	}
+
 	return errs
 }
 
@@ -73,7 +397,7 @@ func (o *Options) AddFlags(fs *pflag.FlagSet) {
 	fs.BoolVar(&o.DisableCaller, flagDisableCaller, o.DisableCaller, "Disable output of caller information in the log.")
 	fs.BoolVar(&o.DisableStacktrace, flagDisableStacktrace,
 		o.DisableStacktrace, "Disable the log to record a stack trace for all messages at or above panic level.")
-	fs.StringVar(&o.Format, flagFormat, o.Format, "Log output `FORMAT`, support plain or json format.")
+	fs.StringVar(&o.Format, flagFormat, o.Format, "Log output `FORMAT`, support plain, json, ecs or a format registered with RegisterEncoder.")
 	fs.StringSliceVar(&o.OutputPaths, flagOutputPaths, o.OutputPaths, "Output paths of log.")
 	fs.BoolVar(
 		&o.Development,
@@ -83,6 +407,116 @@ func (o *Options) AddFlags(fs *pflag.FlagSet) {
 			"the behavior of DPanicLevel and takes stacktraces more liberally.",
 	)
 	fs.StringVar(&o.Name, flagName, o.Name, "The name of the logger.")
+	fs.BoolVar(&o.DisableFatalExit, flagDisableFatalExit, o.DisableFatalExit,
+		"Disable calling os.Exit after writing fatal level logs, useful in tests.")
+	fs.BoolVar(&o.DisablePanic, flagDisablePanic, o.DisablePanic,
+		"Disable panicking after writing panic level logs, useful in tests.")
+	fs.BoolVar(&o.SuppressRepeatedContext, flagSuppressRepeatedContext, o.SuppressRepeatedContext,
+		"Replace the fields of consecutive logs with identical fields by a \"(same context)\" marker.")
+	fs.BoolVar(&o.SplitStdStreams, flagSplitStdStreams, o.SplitStdStreams,
+		"Write warning and higher level logs to stderr instead of stdout.")
+	fs.IntVar(&o.BufferSize, flagBufferSize, o.BufferSize,
+		"Size in bytes of the output buffer shared by a logger and the loggers derived from it, 0 disables buffering.")
+	fs.DurationVar(&o.SamplingReportInterval, flagSamplingReportInterval, o.SamplingReportInterval,
+		"Interval at which a summary of the sampled logs is written, 0 disables the report.")
+	fs.StringVar(&o.ShutdownMessage, flagShutdownMessage, o.ShutdownMessage,
+		"Message logged at info level when the logger is closed, empty disables it.")
+	fs.StringVar(&o.FieldsFromEnv, flagFieldsFromEnv, o.FieldsFromEnv,
+		"Prefix of the environment variables added to every log as fields, keyed by their lowercased suffix.")
+	fs.IntVar(&o.MaxFields, flagMaxFields, o.MaxFields,
+		"Maximum number of fields of a log, the following ones are dropped. 0 means no limit.")
+	fs.DurationVar(&o.FatalGracePeriod, flagFatalGracePeriod, o.FatalGracePeriod,
+		"Maximum time to wait for the outputs to flush before exiting on fatal level logs.")
+	fs.BoolVar(&o.CaptureFormatArgs, flagCaptureFormatArgs, o.CaptureFormatArgs,
+		"Add the arguments of printf-style logs as an args array field.")
+	fs.DurationVar(&o.ErrorCooldown, flagErrorCooldown, o.ErrorCooldown,
+		"Minimum interval between two error logs with the same message, the ones in between are suppressed.")
+	fs.StringVar(&o.CallerEncoder, flagCallerEncoder, o.CallerEncoder,
+		"Caller `FORMAT`, support short (file.go:line), full or twolevel (pkg/subpkg/file.go:line) format.")
+	fs.DurationVar(&o.WriteTimeout, flagWriteTimeout, o.WriteTimeout,
+		"Timeout of the writes to output files, 0 means no timeout.")
+	fs.BoolVar(&o.ConsoleMultiline, flagConsoleMultiline, o.ConsoleMultiline,
+		"Print every field of console format logs indented on its own line, below the message.")
+	fs.BoolVar(&o.PanicDiagnostics, flagPanicDiagnostics, o.PanicDiagnostics,
+		"Add the goroutine count and memory statistics to panic and fatal level logs, and the stacks of all goroutines to fatal ones.")
+	fs.BoolVar(&o.UptimeField, flagUptimeField, o.UptimeField,
+		"Add an uptime_ms field with the milliseconds elapsed since the logger was created to every log.")
+	fs.BoolVar(&o.JSONSeq, flagJSONSeq, o.JSONSeq,
+		"Prefix every json format log with the RS character, to output a JSON text sequence (RFC 7464).")
+	fs.BoolVar(&o.LogSizeAccounting, flagLogSizeAccounting, o.LogSizeAccounting,
+		"Count the bytes written per level, reported by the logger stats.")
+	fs.IntVar(&o.WriteRetryAttempts, flagWriteRetryAttempts, o.WriteRetryAttempts,
+		"Maximum number of retries of a failed write to an output, 0 disables retrying.")
+	fs.DurationVar(&o.WriteRetryBackoff, flagWriteRetryBackoff, o.WriteRetryBackoff,
+		"Time to wait before retrying a failed write, doubled after each retry.")
+	fs.IntVar(&o.MaxEntryBytes, flagMaxEntryBytes, o.MaxEntryBytes,
+		"Maximum encoded size in bytes of a log, larger ones are replaced by their message and size. 0 means no limit.")
+	fs.BoolVar(&o.AllowNoOutput, flagAllowNoOutput, o.AllowNoOutput,
+		"Allow an empty output paths list, disabling logging instead of failing.")
+	fs.IntVar(&o.RingBufferSize, flagRingBufferSize, o.RingBufferSize,
+		"Number of recent logs kept in memory for RecentLogs, 0 disables it.")
+	fs.BoolVar(&o.RawByteSizes, flagRawByteSizes, o.RawByteSizes,
+		"Log the raw number of bytes of byte size fields in a <key>_bytes field, next to the human readable size.")
+	fs.StringVar(&o.NoSampleAbove, flagNoSampleAbove, o.NoSampleAbove,
+		"Never sample logs at this `LEVEL` or above, such as error. Empty samples all levels.")
+	fs.IntVar(&o.StacktraceDepth, flagStacktraceDepth, o.StacktraceDepth,
+		"Maximum number of frames of the logged stack traces, the following ones are replaced by their count. 0 means no limit.")
+	fs.IntVar(&o.MaskLongStrings, flagMaskLongStrings, o.MaskLongStrings,
+		"Length above which string fields are masked, keeping only their first and last 4 characters. 0 disables masking.")
+	fs.BoolVar(&o.PIIRedaction, flagPIIRedaction, o.PIIRedaction,
+		"Replace the likely personal information found in string fields, such as emails, by [PII].")
+	fs.StringArrayVar(&o.PIIPatterns, flagPIIPatterns, o.PIIPatterns,
+		"Regular expressions matching the personal information redacted by pii-redaction, defaults to emails, SSNs and card numbers.")
+	fs.StringVar(&o.EntrySeparator, flagEntrySeparator, o.EntrySeparator,
+		"Text written after console format logs to separate them, such as a newline for a blank line.")
+	fs.StringVar(&o.EntrySeparatorLevel, flagEntrySeparatorLevel, o.EntrySeparatorLevel,
+		"Minimum `LEVEL` of the logs followed by the entry separator, empty for all levels.")
+	fs.StringVar(&o.FlagEvalLevel, flagFlagEvalLevel, o.FlagEvalLevel,
+		"`LEVEL` of the feature flag evaluation logs, debug if empty.")
+	fs.StringVar(&o.FallbackOutputPath, flagFallbackOutputPath, o.FallbackOutputPath,
+		"Output path the logs are written to when writing to their output path fails, such as stderr.")
+	fs.IntVar(&o.RequestScopedSampling, flagRequestScopedSampling, o.RequestScopedSampling,
+		"Maximum number of logs with the same message written by the logger of a context, counted per context. 0 means no limit.")
+	fs.StringVar(&o.NumericLevelKey, flagNumericLevelKey, o.NumericLevelKey,
+		"Key of a field holding the level as an integer, from -1 for debug to 5 for fatal. Empty disables it.")
+	fs.StringVar(&o.AuditOutputPath, flagAuditOutputPath, o.AuditOutputPath,
+		"Output path of the audit logger, written synchronously and never sampled. Empty logs audit events with the main logger.")
+	fs.BoolVar(&o.EncodeErrorRecovery, flagEncodeErrorRecovery, o.EncodeErrorRecovery,
+		"Encode object, array and reflected fields beforehand, replacing the ones failing or panicking with encode_error.")
+	fs.StringVar(&o.TimeFormat, flagTimeFormat, o.TimeFormat,
+		"Format of the time field, a Go time layout or epochnano for integer Unix nanoseconds. Defaults to 2006-01-02 15:04:05.000.")
+	fs.StringArrayVar(&o.IgnoreSyncErrors, flagIgnoreSyncErrors, o.IgnoreSyncErrors,
+		"Regular expressions matching the output sync errors ignored by Sync, defaults to the harmless stdout sync errors on Linux.")
+	fs.IntVar(&o.MaxFieldLength, flagMaxFieldLength, o.MaxFieldLength,
+		"Maximum number of bytes of string fields, JSON objects and arrays being cut between members. 0 means no limit.")
+	fs.IntVar(&o.SinkQueueSize, flagSinkQueueSize, o.SinkQueueSize,
+		"Number of entries queued for each network output, written in the background so that it does not slow down the other outputs. 0 writes synchronously.")
+	fs.BoolVar(&o.StructuredStacktrace, flagStructuredStacktrace, o.StructuredStacktrace,
+		"Write stacktraces as arrays of function, file and line objects in json format, instead of multiline strings.")
+	fs.IntVar(&o.FirstThenSample, flagFirstThenSample, o.FirstThenSample,
+		"Maximum number of logs per second from each caller, the first log of every caller being always written. 0 means no limit.")
+	fs.BoolVar(&o.FormatMismatchCheck, flagFormatMismatchCheck, o.FormatMismatchCheck,
+		"Write printf-style logs whose verbs do not match their arguments as is, with a format_mismatch field and the arguments.")
+	fs.BoolVar(&o.SequenceNumbers, flagSequenceNumbers, o.SequenceNumbers,
+		"Number the logs in a seq field increasing from 1 without gaps, shared by the derived loggers.")
+	fs.StringVar(&o.SamplingKey, flagSamplingKey, o.SamplingKey,
+		"Field whose values, such as tenants, are sampled independently at the sampling key rate. Empty disables the keyed sampling.")
+	fs.IntVar(&o.SamplingKeyLRU, flagSamplingKeyLRU, o.SamplingKeyLRU,
+		"Maximum number of sampling key values counted, the least recently used ones being evicted and restarting from the first entries. 0 means 1024.")
+	fs.BoolVar(&o.Int64AsString, flagInt64AsString, o.Int64AsString,
+		"Write int64 and uint64 fields as strings in json format, so that JavaScript consumers keep the precision of large IDs.")
+	fs.IntVar(&o.ConsoleWrap, flagConsoleWrap, o.ConsoleWrap,
+		"Column at which the messages are wrapped in console format, continuation lines being indented. Not applied when stdout or stderr is piped. 0 means no wrapping.")
+	fs.BoolVar(&o.SampleAnnotation, flagSampleAnnotation, o.SampleAnnotation,
+		"Annotate the logs kept once sampling applies with sampled:true and the sample_rate of logs each of them stands for.")
+	fs.BoolVar(&o.RuntimeFields, flagRuntimeFields, o.RuntimeFields,
+		"Add go_version, goos, goarch and num_cpu fields, computed when the logger is built, to every log.")
+	fs.StringVar(&o.SchemaVersion, flagSchemaVersion, o.SchemaVersion,
+		"Version of the log schema, added as a schema_version field to every log. Empty adds no field.")
+	fs.IntVar(&o.Verbosity, flagVerbosity, o.Verbosity,
+		"Maximum verbosity of the logs written with V(n), the ones with a greater n being dropped. 0, the default, keeps writing V(n) at level n.")
+	fs.BoolVar(&o.CollapseConsecutive, flagCollapseConsecutive, o.CollapseConsecutive,
+		"Collapse consecutive identical logs like uniq, writing (repeated N times) once a different log is written.")
 	// fs.IntVar(&o.MaxSize, flagMaxSize, o.MaxSize, "Maximum size of the logger.")
 	// fs.IntVar(&o.MaxBackups, flagMaxBackups, o.MaxBackups, "Maximum backups of the logger.")
 	// fs.DurationVar(&o.MaxAge, flagMaxAge, o.MaxAge, "Maximum age of the logger.")
@@ -96,9 +530,12 @@ func NewOptions() *Options {
 		DisableCaller:     false,
 		DisableStacktrace: false,
 		Format:            consoleFormat,
+		CallerEncoder:     shortCallerEncoder,
 		Development:       false,
 		OutputPaths:       []string{"stdout"},
 		ErrorOutputPaths:  []string{"stderr"},
+		IgnoreSyncErrors:  append([]string(nil), DefaultIgnoreSyncErrors...),
+		SinkQueueSize:     1024,
 
 		// MaxBackups:     1000,
 		// MaxAge:         24 * 30 * time.Hour,
@@ -107,54 +544,65 @@ func NewOptions() *Options {
 	}
 }
 
+// AddOutputPathIf appends path to the OutputPaths if cond is true and does
+// nothing otherwise, so that environment-driven outputs can be set without
+// branching:
+//
+//	opts := log.NewOptions().AddOutputPathIf(env == "production", "/var/log/app.log")
+//
+// It returns the options for chaining.
+func (o *Options) AddOutputPathIf(cond bool, path string) *Options {
+	if cond {
+		o.OutputPaths = append(o.OutputPaths, path)
+	}
+
+	return o
+}
+
+// AddErrorOutputPathIf appends path to the ErrorOutputPaths if cond is true.
+// It returns the options for chaining.
+func (o *Options) AddErrorOutputPathIf(cond bool, path string) *Options {
+	if cond {
+		o.ErrorOutputPaths = append(o.ErrorOutputPaths, path)
+	}
+
+	return o
+}
+
+// AddSinkIf appends sink to the Sinks if cond is true. It returns the options
+// for chaining.
+func (o *Options) AddSinkIf(cond bool, sink SinkOptions) *Options {
+	if cond {
+		o.Sinks = append(o.Sinks, sink)
+	}
+
+	return o
+}
+
 func (o *Options) String() string {
 	data, _ := json.Marshal(o)
 	return string(data)
 }
 
-// Build constructs a global zap logger from the Config and Options.
+// Build constructs a global zap logger from the Config and Options. The
+// logger is registered to be closed by Shutdown, which releases its sinks.
 func (o *Options) Build() error {
-	var zapLevel zapcore.Level
-	if err := zapLevel.UnmarshalText([]byte(o.Level)); err != nil {
-		zapLevel = zapcore.InfoLevel
-	}
-	encodeLevel := zapcore.CapitalLevelEncoder
-	if o.Format == consoleFormat {
-		encodeLevel = zapcore.CapitalColorLevelEncoder
-	}
-
-	zc := &zap.Config{
-		Level:             zap.NewAtomicLevelAt(zapLevel),
-		Development:       o.Development,
-		DisableCaller:     o.DisableCaller,
-		DisableStacktrace: o.DisableStacktrace,
-		Sampling: &zap.SamplingConfig{
-			Initial:    100,
-			Thereafter: 100,
-		},
-		Encoding: o.Format,
-		EncoderConfig: zapcore.EncoderConfig{
-			MessageKey:     "message",
-			LevelKey:       "level",
-			TimeKey:        "timestamp",
-			NameKey:        "logger",
-			CallerKey:      "caller",
-			StacktraceKey:  "stacktrace",
-			LineEnding:     zapcore.DefaultLineEnding,
-			EncodeLevel:    encodeLevel,
-			EncodeTime:     timeEncoder,
-			EncodeDuration: milliSecondsDurationEncoder,
-			EncodeCaller:   zapcore.ShortCallerEncoder,
-			EncodeName:     zapcore.FullNameEncoder,
-		},
-		OutputPaths:      o.OutputPaths,
-		ErrorOutputPaths: o.ErrorOutputPaths,
-	}
-	logger, err := zc.Build(zap.AddStacktrace(zapcore.PanicLevel))
+	logger, state, err := o.build()
 	if err != nil {
 		return err
 	}
-	zap.RedirectStdLog(logger.Named(o.Name))
+	copied := *o
+	built := &zapLogger{
+		zapLogger: logger.Named(o.Name),
+		infoLogger: infoLogger{
+			log:   logger,
+			level: zap.InfoLevel,
+		},
+		opts:  &copied,
+		state: state,
+	}
+	built.AutoFlushOnExit()
+	zap.RedirectStdLog(built.zapLogger)
 	zap.ReplaceGlobals(logger)
 
 	return nil
@@ -0,0 +1,145 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OutputConfig is the configuration of an output path, as parsed by
+// ParseOutputPath.
+type OutputConfig struct {
+	// Path is the path the output is opened with, a plain file path, stdout,
+	// stderr or the URL of a registered sink without its query.
+	Path string
+	// Level is the minimum level of the entries written to the output, empty
+	// for the level of the logger.
+	Level string
+	// WriteTimeout overrides the WriteTimeout of the options when set.
+	WriteTimeout time.Duration
+	// QueueSize overrides the SinkQueueSize of the options when set.
+	QueueSize int
+	// Rotation is the rotation of a file output.
+	Rotation RotationConfig
+}
+
+// RotationConfig is the rotation of a file output, as set by the parameters
+// of its URL. This build has no rotation support: outputs with a rotation are
+// parsed, but fail to be opened.
+type RotationConfig struct {
+	// MaxSize is the size in megabytes a file is rotated at.
+	MaxSize int
+	// MaxBackups is the number of rotated files kept.
+	MaxBackups int
+	// MaxAge is how long the rotated files are kept.
+	MaxAge time.Duration
+	// Compress reports whether the rotated files are compressed.
+	Compress bool
+}
+
+// errRotationUnsupported is returned when opening an output with a rotation.
+var errRotationUnsupported = errors.New("log rotation is not supported by this build")
+
+// ParseOutputPath parses an output path. Plain paths, such as "stdout" or
+// "/var/log/app.log", are returned as they are. URLs designate the standard
+// streams with "stdout://" and "stderr://", files with "file:///var/log/app.log"
+// and any other registered sink, such as "tcp://collector:5000", and can set
+// the output parameters in their query:
+//
+//	level       minimum level of the entries written to the output, e.g. warn
+//	timeout     write timeout of the output, e.g. 500ms
+//	queue       number of entries queued for a network output, e.g. 4096
+//	maxsize     size in megabytes a file is rotated at, e.g. 100
+//	maxbackups  number of rotated files kept, e.g. 5
+//	maxage      how long the rotated files are kept, e.g. 168h
+//	compress    whether the rotated files are compressed, e.g. true
+//
+// The rotation parameters only apply to files, and opening an output with
+// them fails as long as the logger has no rotation support.
+func ParseOutputPath(path string) (OutputConfig, error) {
+	if !strings.Contains(path, "://") {
+		return OutputConfig{Path: path}, nil
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return OutputConfig{}, err
+	}
+
+	var cfg OutputConfig
+	switch u.Scheme {
+	case "stdout", "stderr":
+		if u.Host != "" || u.Path != "" {
+			return OutputConfig{}, fmt.Errorf("output path %q: %s takes no location", path, u.Scheme)
+		}
+		cfg.Path = u.Scheme
+	case "file":
+		if u.Host != "" && u.Host != "localhost" {
+			return OutputConfig{}, fmt.Errorf("output path %q: file URLs cannot have a host", path)
+		}
+		cfg.Path = u.Path
+	default:
+		stripped := *u
+		stripped.RawQuery = ""
+		cfg.Path = stripped.String()
+	}
+
+	query := u.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := query.Get(key)
+		switch key {
+		case "level":
+			cfg.Level = value
+		case "timeout":
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return OutputConfig{}, fmt.Errorf("output path %q: not a valid timeout: %q", path, value)
+			}
+			cfg.WriteTimeout = d
+		case "queue":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return OutputConfig{}, fmt.Errorf("output path %q: not a valid queue size: %q", path, value)
+			}
+			cfg.QueueSize = n
+		case "maxsize", "maxbackups":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return OutputConfig{}, fmt.Errorf("output path %q: not a valid %s: %q", path, key, value)
+			}
+			if key == "maxsize" {
+				cfg.Rotation.MaxSize = n
+			} else {
+				cfg.Rotation.MaxBackups = n
+			}
+		case "maxage":
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return OutputConfig{}, fmt.Errorf("output path %q: not a valid maxage: %q", path, value)
+			}
+			cfg.Rotation.MaxAge = d
+		case "compress":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return OutputConfig{}, fmt.Errorf("output path %q: not a valid compress: %q", path, value)
+			}
+			cfg.Rotation.Compress = b
+		default:
+			return OutputConfig{}, fmt.Errorf("output path %q: unsupported parameter %q", path, key)
+		}
+	}
+
+	if cfg.Rotation != (RotationConfig{}) && u.Scheme != "file" {
+		return OutputConfig{}, fmt.Errorf("output path %q: rotation parameters only apply to files", path)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,59 @@
+package log
+
+import (
+	"regexp"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// piiReplacement replaces the matches of the PII patterns.
+const piiReplacement = "[PII]"
+
+// DefaultPIIPatterns are the patterns redacted by PIIRedaction when no
+// PIIPatterns are configured: email addresses, US social security numbers and
+// payment card numbers.
+var DefaultPIIPatterns = []string{
+	`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	`\b\d{3}-\d{2}-\d{4}\b`,
+	`\b\d{4}[ -]?\d{4}[ -]?\d{4}[ -]?\d{1,4}\b`,
+}
+
+// piiPatterns compiles the configured PII patterns, falling back to the
+// default ones.
+func (o *Options) piiPatterns() ([]*regexp.Regexp, error) {
+	patterns := o.PIIPatterns
+	if len(patterns) == 0 {
+		patterns = DefaultPIIPatterns
+	}
+
+	return compilePatterns(patterns)
+}
+
+// redactPII returns a field rewrite replacing the matches of the patterns in
+// string values by "[PII]".
+func redactPII(patterns []*regexp.Regexp) func([]zapcore.Field) []zapcore.Field {
+	return func(fields []zapcore.Field) []zapcore.Field {
+		var out []zapcore.Field
+		for i, f := range fields {
+			if f.Type != zapcore.StringType {
+				continue
+			}
+			redacted := f.String
+			for _, re := range patterns {
+				redacted = re.ReplaceAllLiteralString(redacted, piiReplacement)
+			}
+			if redacted == f.String {
+				continue
+			}
+			if out == nil {
+				out = append(make([]zapcore.Field, 0, len(fields)), fields...)
+			}
+			out[i].String = redacted
+		}
+		if out == nil {
+			return fields
+		}
+
+		return out
+	}
+}
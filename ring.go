@@ -0,0 +1,64 @@
+package log
+
+import (
+	"strings"
+	"sync"
+)
+
+// ringBuffer is a zapcore.WriteSyncer keeping the last encoded entries in
+// memory, overwriting the oldest ones once full.
+type ringBuffer struct {
+	mu      sync.Mutex
+	entries []string
+	// next is the index the next entry is written at.
+	next int
+	full bool
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{entries: make([]string, size)}
+}
+
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	// zap reuses p once Write returns, converting to a string copies it.
+	entry := strings.TrimSuffix(string(p), "\n")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+
+	return len(p), nil
+}
+
+func (r *ringBuffer) Sync() error { return nil }
+
+// snapshot returns the entries in the buffer, oldest first.
+func (r *ringBuffer) snapshot() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.full {
+		return append([]string(nil), r.entries[:r.next]...)
+	}
+
+	return append(append(make([]string, 0, len(r.entries)), r.entries[r.next:]...), r.entries[:r.next]...)
+}
+
+// RecentLogs returns the most recent entries of the std logger.
+func RecentLogs() []string { return std().RecentLogs() }
+
+// RecentLogs returns the last RingBufferSize entries written by the logger or
+// by any logger sharing its core, oldest first, as encoded in the configured
+// format without the trailing newline. It returns nil without RingBufferSize.
+func (l *zapLogger) RecentLogs() []string {
+	if l.state == nil || l.state.ring == nil {
+		return nil
+	}
+
+	return l.state.ring.snapshot()
+}
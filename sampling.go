@@ -0,0 +1,728 @@
+package log
+
+import (
+	"container/list"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	samplingReportMessage = "sampling report"
+	// samplerFirst and samplerThereafter are the rates of the sampler: in
+	// every second, the first samplerFirst entries with a given level and
+	// message are kept, then every samplerThereafter-th one.
+	samplerFirst      = 100
+	samplerThereafter = 100
+	// maxCooldownFingerprints bounds the error messages remembered by the
+	// cooldown, expired ones are evicted once it is reached.
+	maxCooldownFingerprints = 1 << 12
+	// maxReportedMessages bounds the distinct messages remembered between two
+	// sampling reports.
+	maxReportedMessages = 1 << 16
+	// maxRequestSampledMessages bounds the distinct messages counted by a
+	// request scoped sampler, the following ones are not sampled.
+	maxRequestSampledMessages = 1 << 10
+	// maxSampledCallers bounds the callers remembered by FirstThenSample, the
+	// ones whose second is over are evicted once it is reached.
+	maxSampledCallers = 1 << 12
+	// maxAnnotatedMessages bounds the messages counted by SampleAnnotation,
+	// the following ones are not annotated.
+	maxAnnotatedMessages = 1 << 12
+	// defaultSamplingKeyLRU is the number of sampling key values counted when
+	// SamplingKeyLRU is not set.
+	defaultSamplingKeyLRU = 1 << 10
+)
+
+// samplingReporter accumulates the sampler decisions and periodically logs a
+// summary of them.
+type samplingReporter struct {
+	mu       sync.Mutex
+	kept     uint64
+	dropped  uint64
+	messages map[string]struct{}
+}
+
+func newSamplingReporter() *samplingReporter {
+	return &samplingReporter{messages: map[string]struct{}{}}
+}
+
+func (r *samplingReporter) hook(ent zapcore.Entry, dec zapcore.SamplingDecision) {
+	if ent.Message == samplingReportMessage {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if dec&zapcore.LogDropped != 0 {
+		r.dropped++
+	} else {
+		r.kept++
+	}
+	if len(r.messages) < maxReportedMessages {
+		r.messages[ent.Message] = struct{}{}
+	}
+}
+
+// report logs the decisions since the previous report and resets them.
+func (r *samplingReporter) report(l *zap.Logger) {
+	r.mu.Lock()
+	kept, dropped, messages := r.kept, r.dropped, len(r.messages)
+	r.kept, r.dropped = 0, 0
+	r.messages = map[string]struct{}{}
+	r.mu.Unlock()
+
+	if kept+dropped == 0 {
+		return
+	}
+	l.Info(samplingReportMessage,
+		zap.Uint64("kept", kept),
+		zap.Uint64("dropped", dropped),
+		zap.Int("messages", messages),
+	)
+}
+
+// start reports every interval until the returned function is called.
+func (r *samplingReporter) start(l *zap.Logger, clock zapcore.Clock, interval time.Duration) func() {
+	ticker := clock.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.report(l)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// samplerHooks combines several sampler hooks into one.
+func samplerHooks(hooks ...func(zapcore.Entry, zapcore.SamplingDecision)) func(zapcore.Entry, zapcore.SamplingDecision) {
+	return func(ent zapcore.Entry, dec zapcore.SamplingDecision) {
+		for _, hook := range hooks {
+			hook(ent, dec)
+		}
+	}
+}
+
+// cooldownCore is a zapcore.Core which drops error entries whose message was
+// already written less than cooldown ago. Once the cooldown is over, the
+// number of entries dropped in the meantime is carried by the next entry with
+// the same message, or if there is none by the time another entry is written
+// or the core is synced, by a summary: the first dropped entry annotated with
+// the count.
+type cooldownCore struct {
+	zapcore.Core
+	cooldown time.Duration
+	clock    zapcore.Clock
+	state    *cooldownState
+	stats    *stats
+}
+
+type cooldownState struct {
+	mu   sync.Mutex
+	seen map[string]*cooldownEntry
+	// pending is the number of messages with suppressed entries, and next the
+	// time the earliest of their cooldowns is over. pending is also read
+	// without mu, for the writes not to contend on it when there is none.
+	pending atomic.Int64
+	next    time.Time
+}
+
+type cooldownEntry struct {
+	last       time.Time
+	suppressed int
+	// summary is the first entry suppressed since last.
+	summary cooldownSummary
+}
+
+// cooldownSummary is an entry written with the number of entries suppressed
+// after it.
+type cooldownSummary struct {
+	core       zapcore.Core
+	ent        zapcore.Entry
+	fields     []zapcore.Field
+	suppressed int
+}
+
+func newCooldownCore(core zapcore.Core, cooldown time.Duration, clock zapcore.Clock, st *stats) zapcore.Core {
+	return &cooldownCore{
+		Core:     core,
+		cooldown: cooldown,
+		clock:    clock,
+		state:    &cooldownState{seen: map[string]*cooldownEntry{}},
+		stats:    st,
+	}
+}
+
+func (c *cooldownCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.Core = c.Core.With(fields)
+
+	return &clone
+}
+
+func (c *cooldownCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *cooldownCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if ent.Level != zapcore.ErrorLevel {
+		writeSummaries(c.state.expired(ent.Time, c.cooldown, false), ent.Time)
+
+		return c.Core.Write(ent, fields)
+	}
+
+	suppressed, ok, due := c.state.allow(c.Core, ent, fields, c.cooldown)
+	writeSummaries(due, ent.Time)
+	if !ok {
+		c.stats.deduped.inc(ent.Level)
+
+		return nil
+	}
+	if suppressed > 0 {
+		fields = append(fields[:len(fields):len(fields)], zap.Int("suppressed", suppressed))
+	}
+
+	return c.Core.Write(ent, fields)
+}
+
+func (c *cooldownCore) Sync() error {
+	now := c.clock.Now()
+	writeSummaries(c.state.expired(now, c.cooldown, true), now)
+
+	return c.Core.Sync()
+}
+
+// writeSummaries writes the summaries at t.
+func writeSummaries(summaries []cooldownSummary, t time.Time) {
+	for _, summary := range summaries {
+		ent := summary.ent
+		ent.Time = t
+		fields := append(summary.fields, zap.Int("suppressed", summary.suppressed))
+		_ = summary.core.Write(ent, fields)
+	}
+}
+
+// allow reports whether an entry with the message may be written at t, and
+// how many entries were suppressed since the last one was. It also returns the
+// summaries due for the other messages.
+func (s *cooldownState) allow(core zapcore.Core, ent zapcore.Entry, fields []zapcore.Field, cooldown time.Duration) (int, bool, []cooldownSummary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := ent.Time
+	e, ok := s.seen[ent.Message]
+	if ok && t.Sub(e.last) < cooldown {
+		if e.suppressed == 0 {
+			e.summary = cooldownSummary{core: core, ent: ent, fields: append([]zapcore.Field(nil), fields...)}
+			s.pending.Add(1)
+			if expiry := e.last.Add(cooldown); s.next.IsZero() || expiry.Before(s.next) {
+				s.next = expiry
+			}
+		}
+		e.suppressed++
+
+		return 0, false, s.expiredLocked(t, cooldown, false)
+	}
+
+	var due []cooldownSummary
+	if !ok {
+		if len(s.seen) >= maxCooldownFingerprints {
+			due = s.evict(t, cooldown)
+		}
+		e = &cooldownEntry{}
+		s.seen[ent.Message] = e
+	}
+	suppressed := e.suppressed
+	if suppressed > 0 {
+		s.pending.Add(-1)
+	}
+	e.last, e.suppressed, e.summary = t, 0, cooldownSummary{}
+
+	return suppressed, true, append(due, s.expiredLocked(t, cooldown, false)...)
+}
+
+// expired returns the summaries of the messages whose cooldown is over at t,
+// or of all the messages with suppressed entries if all is set, and resets
+// their counts.
+func (s *cooldownState) expired(t time.Time, cooldown time.Duration, all bool) []cooldownSummary {
+	if s.pending.Load() == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.expiredLocked(t, cooldown, all)
+}
+
+func (s *cooldownState) expiredLocked(t time.Time, cooldown time.Duration, all bool) []cooldownSummary {
+	if s.pending.Load() == 0 || !all && t.Before(s.next) {
+		return nil
+	}
+
+	var due []cooldownSummary
+	s.next = time.Time{}
+	for _, e := range s.seen {
+		if e.suppressed == 0 {
+			continue
+		}
+		if expiry := e.last.Add(cooldown); !all && t.Before(expiry) {
+			if s.next.IsZero() || expiry.Before(s.next) {
+				s.next = expiry
+			}
+
+			continue
+		}
+		due = append(due, e.takeSummary())
+		s.pending.Add(-1)
+	}
+
+	return due
+}
+
+// evict forgets the messages whose cooldown is over, returning the summaries
+// of those with suppressed entries.
+func (s *cooldownState) evict(t time.Time, cooldown time.Duration) []cooldownSummary {
+	var due []cooldownSummary
+	for msg, e := range s.seen {
+		if t.Sub(e.last) < cooldown {
+			continue
+		}
+		if e.suppressed > 0 {
+			due = append(due, e.takeSummary())
+			s.pending.Add(-1)
+		}
+		delete(s.seen, msg)
+	}
+
+	return due
+}
+
+// takeSummary returns the summary of the entries suppressed since last, and
+// resets their count.
+func (e *cooldownEntry) takeSummary() cooldownSummary {
+	summary := e.summary
+	summary.suppressed = e.suppressed
+	e.suppressed, e.summary = 0, cooldownSummary{}
+
+	return summary
+}
+
+// SampleRate is the sampling applied to the entries with a given message. In
+// every Tick, the First entries are written, then only every Thereafter-th
+// one. A zero Tick means one second, a zero Thereafter drops all the entries
+// after the First ones.
+type SampleRate struct {
+	Tick       time.Duration `json:"tick"       mapstructure:"tick"`
+	First      int           `json:"first"      mapstructure:"first"`
+	Thereafter int           `json:"thereafter" mapstructure:"thereafter"`
+}
+
+// messageSamplerCore is a zapcore.Core sampling the entries whose message has
+// a SampleRate, independently of the global sampler. The other entries are
+// passed through untouched.
+type messageSamplerCore struct {
+	zapcore.Core
+	counters map[string]*messageCounter
+	stats    *stats
+}
+
+type messageCounter struct {
+	rate SampleRate
+
+	mu    sync.Mutex
+	reset time.Time
+	n     int
+}
+
+func newMessageSamplerCore(core zapcore.Core, rates map[string]SampleRate, st *stats) zapcore.Core {
+	counters := make(map[string]*messageCounter, len(rates))
+	for msg, rate := range rates {
+		if rate.Tick <= 0 {
+			rate.Tick = time.Second
+		}
+		counters[msg] = &messageCounter{rate: rate}
+	}
+
+	return &messageSamplerCore{Core: core, counters: counters, stats: st}
+}
+
+func (c *messageSamplerCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.Core = c.Core.With(fields)
+
+	return &clone
+}
+
+func (c *messageSamplerCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *messageSamplerCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if counter, ok := c.counters[ent.Message]; ok && !counter.allow(ent.Time) {
+		c.stats.sampledOut.inc(ent.Level)
+
+		return nil
+	}
+
+	return c.Core.Write(ent, fields)
+}
+
+// allow reports whether an entry written at t is kept by the sampling.
+func (c *messageCounter) allow(t time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !t.Before(c.reset) {
+		c.reset, c.n = t.Add(c.rate.Tick), 0
+	}
+	c.n++
+	if c.n <= c.rate.First {
+		return true
+	}
+
+	return c.rate.Thereafter > 0 && (c.n-c.rate.First)%c.rate.Thereafter == 0
+}
+
+// requestSamplerCore is a zapcore.Core writing at most n entries per message,
+// counted from its creation. One is created for every context a logger is
+// stored in with RequestScopedSampling, which scopes the counters to a
+// request. The loggers derived from it share its counters.
+type requestSamplerCore struct {
+	zapcore.Core
+	n      int
+	counts *requestCounts
+	stats  *stats
+}
+
+type requestCounts struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newRequestSamplerCore(core zapcore.Core, n int, st *stats) zapcore.Core {
+	return &requestSamplerCore{
+		Core:   core,
+		n:      n,
+		counts: &requestCounts{counts: map[string]int{}},
+		stats:  st,
+	}
+}
+
+func (c *requestSamplerCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.Core = c.Core.With(fields)
+
+	return &clone
+}
+
+func (c *requestSamplerCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Enabled(ent.Level) {
+		return ce
+	}
+	if !c.counts.allow(ent.Message, c.n) {
+		if c.stats != nil {
+			c.stats.sampledOut.inc(ent.Level)
+		}
+
+		return ce
+	}
+
+	return c.Core.Check(ent, ce)
+}
+
+// allow counts an entry with the message and reports whether it is kept.
+func (rc *requestCounts) allow(msg string, n int) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	count, ok := rc.counts[msg]
+	if !ok && len(rc.counts) >= maxRequestSampledMessages {
+		return true
+	}
+	rc.counts[msg] = count + 1
+
+	return count < n
+}
+
+// callerSamplerCore is a zapcore.Core writing at most perSec entries per
+// second from each caller, so that the first entry of every code path is
+// always written however much the other ones log. Entries without a caller
+// are counted by message.
+type callerSamplerCore struct {
+	zapcore.Core
+	perSec int
+	state  *secondCounts
+	stats  *stats
+}
+
+// secondCounts counts the entries by key in every second, remembering at most
+// max keys. The keys whose second is over are evicted once it is reached.
+type secondCounts struct {
+	max int
+
+	mu     sync.Mutex
+	counts map[string]*secondCount
+}
+
+type secondCount struct {
+	reset time.Time
+	n     int
+}
+
+func newSecondCounts(max int) *secondCounts {
+	return &secondCounts{max: max, counts: map[string]*secondCount{}}
+}
+
+func newCallerSamplerCore(core zapcore.Core, perSec int, st *stats) zapcore.Core {
+	return &callerSamplerCore{
+		Core:   core,
+		perSec: perSec,
+		state:  newSecondCounts(maxSampledCallers),
+		stats:  st,
+	}
+}
+
+func (c *callerSamplerCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.Core = c.Core.With(fields)
+
+	return &clone
+}
+
+func (c *callerSamplerCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *callerSamplerCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	key := ent.Message
+	if ent.Caller.Defined {
+		key = ent.Caller.File + ":" + strconv.Itoa(ent.Caller.Line)
+	}
+	// A caller not seen yet is always kept, even when too many callers are
+	// remembered to count it.
+	if n, ok := c.state.inc(key, ent.Time); ok && n > c.perSec {
+		c.stats.sampledOut.inc(ent.Level)
+
+		return nil
+	}
+
+	return c.Core.Write(ent, fields)
+}
+
+// inc counts an entry with the key at t and returns the number of entries
+// counted in its second so far. It returns false when too many keys are
+// remembered to count a new one.
+func (sc *secondCounts) inc(key string, t time.Time) (int, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	count, ok := sc.counts[key]
+	if !ok {
+		if len(sc.counts) >= sc.max {
+			sc.evict(t)
+			if len(sc.counts) >= sc.max {
+				return 0, false
+			}
+		}
+		count = &secondCount{}
+		sc.counts[key] = count
+	}
+	if !t.Before(count.reset) {
+		count.reset, count.n = t.Add(time.Second), 0
+	}
+	count.n++
+
+	return count.n, true
+}
+
+// evict forgets the keys whose second is over at t.
+func (sc *secondCounts) evict(t time.Time) {
+	for key, count := range sc.counts {
+		if !t.Before(count.reset) {
+			delete(sc.counts, key)
+		}
+	}
+}
+
+// sampleAnnotationCore wraps the sampler to annotate the entries it keeps
+// once it samples: the entries past the first samplerFirst of their second
+// are sampled:true and carry the number of entries each of them stands for
+// as sample_rate. It counts the entries the same way as the sampler, but
+// separately, and routes the ones to annotate to a child of the sampler,
+// which shares its counters.
+type sampleAnnotationCore struct {
+	zapcore.Core
+	annotated zapcore.Core
+	counts    *secondCounts
+}
+
+func newSampleAnnotationCore(sampler zapcore.Core) zapcore.Core {
+	return &sampleAnnotationCore{
+		Core:      sampler,
+		annotated: sampler.With([]zapcore.Field{zap.Bool("sampled", true), zap.Int("sample_rate", samplerThereafter)}),
+		counts:    newSecondCounts(maxAnnotatedMessages),
+	}
+}
+
+func (c *sampleAnnotationCore) With(fields []zapcore.Field) zapcore.Core {
+	return &sampleAnnotationCore{Core: c.Core.With(fields), annotated: c.annotated.With(fields), counts: c.counts}
+}
+
+func (c *sampleAnnotationCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Enabled(ent.Level) {
+		return ce
+	}
+	if n, ok := c.counts.inc(ent.Level.String()+":"+ent.Message, ent.Time); ok && n > samplerFirst {
+		return c.annotated.Check(ent, ce)
+	}
+
+	return c.Core.Check(ent, ce)
+}
+
+// keyedSamplerCore is a zapcore.Core sampling the entries by the value of a
+// field, such as a tenant, each value having its own counter. The counters of
+// at most maxKeys values are kept, the least recently used one being evicted
+// once it is reached, so that a value seen again afterwards restarts from the
+// First entries. Entries without the field are passed through untouched.
+type keyedSamplerCore struct {
+	zapcore.Core
+	key   string
+	value string
+	found bool
+	state *keyedCounters
+	stats *stats
+}
+
+type keyedCounters struct {
+	rate    SampleRate
+	maxKeys int
+
+	mu       sync.Mutex
+	order    *list.List
+	counters map[string]*list.Element
+}
+
+type keyedCounter struct {
+	value   string
+	counter *messageCounter
+}
+
+func newKeyedSamplerCore(core zapcore.Core, key string, rate SampleRate, maxKeys int, st *stats) zapcore.Core {
+	if rate.Tick <= 0 {
+		rate.Tick = time.Second
+	}
+	if maxKeys <= 0 {
+		maxKeys = defaultSamplingKeyLRU
+	}
+
+	return &keyedSamplerCore{
+		Core: core,
+		key:  key,
+		state: &keyedCounters{
+			rate:     rate,
+			maxKeys:  maxKeys,
+			order:    list.New(),
+			counters: map[string]*list.Element{},
+		},
+		stats: st,
+	}
+}
+
+func (c *keyedSamplerCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.Core = c.Core.With(fields)
+	if value, ok := keyValue(c.key, fields); ok {
+		clone.value, clone.found = value, true
+	}
+
+	return &clone
+}
+
+func (c *keyedSamplerCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *keyedSamplerCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	value, ok := keyValue(c.key, fields)
+	if !ok {
+		value, ok = c.value, c.found
+	}
+	if ok && !c.state.counter(value).allow(ent.Time) {
+		c.stats.sampledOut.inc(ent.Level)
+
+		return nil
+	}
+
+	return c.Core.Write(ent, fields)
+}
+
+// counter returns the counter of value, creating it and evicting the least
+// recently used one if needed.
+func (kc *keyedCounters) counter(value string) *messageCounter {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	if elem, ok := kc.counters[value]; ok {
+		kc.order.MoveToFront(elem)
+
+		return elem.Value.(*keyedCounter).counter
+	}
+	if kc.order.Len() >= kc.maxKeys {
+		oldest := kc.order.Back()
+		kc.order.Remove(oldest)
+		delete(kc.counters, oldest.Value.(*keyedCounter).value)
+	}
+	counter := &messageCounter{rate: kc.rate}
+	kc.counters[value] = kc.order.PushFront(&keyedCounter{value: value, counter: counter})
+
+	return counter
+}
+
+// keyValue returns the value of the last field named key, formatted as a
+// string.
+func keyValue(key string, fields []zapcore.Field) (string, bool) {
+	for i := len(fields) - 1; i >= 0; i-- {
+		f := fields[i]
+		if f.Key != key {
+			continue
+		}
+		if f.Type == zapcore.StringType {
+			return f.String, true
+		}
+		enc := zapcore.NewMapObjectEncoder()
+		f.AddTo(enc)
+
+		return fmt.Sprint(enc.Fields[key]), true
+	}
+
+	return "", false
+}
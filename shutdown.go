@@ -0,0 +1,95 @@
+package log
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// shutdownRegistry holds the loggers closed by Shutdown.
+var shutdownRegistry struct {
+	mu      sync.Mutex
+	loggers []*zapLogger
+}
+
+// AutoFlushOnExit registers the std logger to be closed by Shutdown.
+func AutoFlushOnExit() { std().AutoFlushOnExit() }
+
+// AutoFlushOnExit registers the logger to be flushed and closed by Shutdown,
+// so that the entries still buffered are not lost when the program exits.
+//
+// Go has no atexit: nothing runs when main returns, os.Exit is called or the
+// process is killed by a signal, and finalizers are not guaranteed to run at
+// all. Shutdown must therefore be called explicitly, the recommended pattern
+// being to defer it first thing in main and to install ShutdownOnSignal for
+// the termination signals:
+//
+//	func main() {
+//		log.AutoFlushOnExit()
+//		defer log.Shutdown()
+//		defer log.ShutdownOnSignal()()
+//		...
+//	}
+//
+// Fatal exits without running the deferred calls, but flushes the logger it
+// is called on itself.
+func (l *zapLogger) AutoFlushOnExit() {
+	shutdownRegistry.mu.Lock()
+	defer shutdownRegistry.mu.Unlock()
+
+	for _, registered := range shutdownRegistry.loggers {
+		if registered == l {
+			return
+		}
+	}
+	shutdownRegistry.loggers = append(shutdownRegistry.loggers, l)
+}
+
+// Shutdown closes the loggers registered with AutoFlushOnExit, the last
+// registered first, and empties the registry. It is safe to call it more than
+// once.
+func Shutdown() {
+	shutdownRegistry.mu.Lock()
+	loggers := shutdownRegistry.loggers
+	shutdownRegistry.loggers = nil
+	shutdownRegistry.mu.Unlock()
+
+	for i := len(loggers) - 1; i >= 0; i-- {
+		loggers[i].Close()
+	}
+}
+
+// ShutdownOnSignal calls Shutdown when the process receives one of the
+// signals, SIGINT and SIGTERM by default, then lets the signal terminate the
+// process as it would have without the handler. It returns a func removing
+// the handler.
+func ShutdownOnSignal(sigs ...os.Signal) func() {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, sigs...)
+
+	go func() {
+		select {
+		case sig := <-ch:
+			Shutdown()
+			signal.Reset(sigs...)
+			if p, err := os.FindProcess(os.Getpid()); err != nil || p.Signal(sig) != nil {
+				os.Exit(1)
+			}
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// isStdStream reports whether the output path is one of the standard streams.
+func isStdStream(path string) bool {
+	return path == "stdout" || path == "stderr"
+}
+
+// wrapSink applies the optional sink wrappers enabled by the options, or by
+// the output parameters, to the sink opened for the output.
+func (o *Options) wrapSink(cfg OutputConfig, sink zapcore.WriteSyncer) zapcore.WriteSyncer {
+	timeout := o.WriteTimeout
+	if cfg.WriteTimeout > 0 {
+		timeout = cfg.WriteTimeout
+	}
+	if timeout > 0 && !isStdStream(cfg.Path) {
+		sink = newTimeoutWriteSyncer(sink, timeout)
+	}
+	if o.WriteRetryAttempts > 0 {
+		sink = &retryWriteSyncer{
+			WriteSyncer: sink,
+			attempts:    o.WriteRetryAttempts,
+			backoff:     o.WriteRetryBackoff,
+		}
+	}
+
+	return sink
+}
+
+// errSinkTimedOut is wrapped by the errors of the writes and syncs which timed
+// out while still running: retrying them would duplicate the entries once
+// they complete.
+var errSinkTimedOut = errors.New("timed out")
+
+// errSinkDropped is wrapped by the errors of the writes which a sink dropped
+// without even trying them, for the entries to be counted in the stats.
+var errSinkDropped = errors.New("dropped")
+
+// timeoutWriteSyncer is a zapcore.WriteSyncer which gives up on writes and
+// syncs taking longer than timeout, so that a slow disk does not stall the
+// callers. A timed out write is not cancelled: it keeps running in the
+// background, and until it returns the following writes and syncs are dropped
+// at once with an error which, like all errors of the sinks, is reported to
+// the error output. At most one operation is thus running at any time.
+type timeoutWriteSyncer struct {
+	zapcore.WriteSyncer
+	timeout time.Duration
+	// sem is held by the write or sync in progress.
+	sem chan struct{}
+	// dropped is the number of writes and syncs dropped so far.
+	dropped atomic.Uint64
+}
+
+func newTimeoutWriteSyncer(ws zapcore.WriteSyncer, timeout time.Duration) *timeoutWriteSyncer {
+	return &timeoutWriteSyncer{
+		WriteSyncer: ws,
+		timeout:     timeout,
+		sem:         make(chan struct{}, 1),
+	}
+}
+
+func (w *timeoutWriteSyncer) Write(p []byte) (int, error) {
+	// zap reuses p once Write returns, while the write may still be running.
+	buf := append([]byte(nil), p...)
+
+	return w.do("write", func() (int, error) {
+		return w.WriteSyncer.Write(buf)
+	})
+}
+
+func (w *timeoutWriteSyncer) Sync() error {
+	_, err := w.do("sync", func() (int, error) {
+		return 0, w.WriteSyncer.Sync()
+	})
+
+	return err
+}
+
+// timeoutResult is the outcome of an operation run by timeoutWriteSyncer.do.
+type timeoutResult struct {
+	n   int
+	err error
+}
+
+// do runs fn in a goroutine, waiting for it at most timeout. It drops fn if
+// the previous operation is still running. The count returned by fn is only
+// passed on if it returns in time, 0 being returned otherwise.
+func (w *timeoutWriteSyncer) do(op string, fn func() (int, error)) (int, error) {
+	select {
+	case w.sem <- struct{}{}:
+	default:
+		dropped := w.dropped.Add(1)
+
+		return 0, fmt.Errorf("log sink %s %w: previous operation still blocked, %d dropped so far", op, errSinkDropped, dropped)
+	}
+
+	done := make(chan timeoutResult, 1)
+	go func() {
+		defer func() { <-w.sem }()
+		n, err := fn()
+		done <- timeoutResult{n: n, err: err}
+	}()
+
+	timer := time.NewTimer(w.timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-done:
+		return res.n, res.err
+	case <-timer.C:
+		return 0, fmt.Errorf("log sink %s %w after %s", op, errSinkTimedOut, w.timeout)
+	}
+}
+
+// maxWriteRetryDuration bounds the time a write spends waiting between
+// retries, whatever the number of attempts and the backoff.
+const maxWriteRetryDuration = 5 * time.Second
+
+// retryWriteSyncer is a zapcore.WriteSyncer retrying failed writes up to
+// attempts times, waiting backoff before the first retry and doubling it each
+// time. The error of the last attempt is returned, for zap to report it to
+// the error output. Writes which timed out are not retried.
+type retryWriteSyncer struct {
+	zapcore.WriteSyncer
+	attempts int
+	backoff  time.Duration
+}
+
+func (w *retryWriteSyncer) Write(p []byte) (int, error) {
+	written, err := w.WriteSyncer.Write(p)
+	deadline := time.Now().Add(maxWriteRetryDuration)
+	backoff := w.backoff
+	for i := 0; err != nil && i < w.attempts; i++ {
+		if errors.Is(err, errSinkTimedOut) || time.Now().Add(backoff).After(deadline) {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+
+		// Only write what is left from a partial write.
+		var n int
+		n, err = w.WriteSyncer.Write(p[written:])
+		written += n
+	}
+	if err != nil {
+		return written, fmt.Errorf("log sink write failed after %d retries: %w", w.attempts, err)
+	}
+
+	return written, nil
+}
+
+// fallbackWriteSyncer is a zapcore.WriteSyncer writing to fallback what could
+// not be written to the wrapped sink, opened for path. The first time it falls
+// back, it also writes a warning to fallback.
+type fallbackWriteSyncer struct {
+	zapcore.WriteSyncer
+	path     string
+	fallback zapcore.WriteSyncer
+	warned   atomic.Bool
+}
+
+func (w *fallbackWriteSyncer) Write(p []byte) (int, error) {
+	n, err := w.WriteSyncer.Write(p)
+	if err == nil {
+		return n, nil
+	}
+
+	if !w.warned.Swap(true) {
+		_, _ = fmt.Fprintf(w.fallback, "log output %q failed, writing to the fallback output: %v\n", w.path, err)
+	}
+	if _, ferr := w.fallback.Write(p); ferr != nil {
+		return n, errors.Join(err, ferr)
+	}
+
+	return len(p), nil
+}
+
+func (w *fallbackWriteSyncer) Sync() error {
+	return errors.Join(w.WriteSyncer.Sync(), w.fallback.Sync())
+}
+
+// maxQueueSyncWait bounds the time Sync waits for a queued sink to write the
+// entries queued before it.
+const maxQueueSyncWait = 5 * time.Second
+
+// isNetworkSink reports whether the output path designates a network
+// connection.
+func isNetworkSink(path string) bool {
+	return strings.HasPrefix(path, "tcp://")
+}
+
+// queueWriteSyncer is a zapcore.WriteSyncer handing the writes over to a
+// goroutine through a queue of size entries, so that a slow sink does not stall
+// the callers nor the other outputs. Writes are dropped with an error while
+// the queue is full, and the error of a background write is returned by the
+// following call.
+type queueWriteSyncer struct {
+	ws    zapcore.WriteSyncer
+	queue chan queuedWrite
+	stop  chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// queuedWrite is either an entry to write, or a sync request when synced is
+// set.
+type queuedWrite struct {
+	p      []byte
+	synced chan error
+}
+
+func newQueueWriteSyncer(ws zapcore.WriteSyncer, size int) *queueWriteSyncer {
+	w := &queueWriteSyncer{
+		ws:    ws,
+		queue: make(chan queuedWrite, size),
+		stop:  make(chan struct{}),
+	}
+	go w.run()
+
+	return w
+}
+
+func (w *queueWriteSyncer) run() {
+	for {
+		select {
+		case q := <-w.queue:
+			if q.synced != nil {
+				q.synced <- w.ws.Sync()
+
+				continue
+			}
+			if _, err := w.ws.Write(q.p); err != nil {
+				w.mu.Lock()
+				w.err = err
+				w.mu.Unlock()
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+func (w *queueWriteSyncer) Write(p []byte) (int, error) {
+	// zap reuses p once Write returns.
+	select {
+	case w.queue <- queuedWrite{p: append([]byte(nil), p...)}:
+	default:
+		return 0, errors.Join(w.takeErr(), fmt.Errorf("log sink queue full, %d entries waiting: entry %w", cap(w.queue), errSinkDropped))
+	}
+
+	return len(p), w.takeErr()
+}
+
+// Sync waits for the entries queued so far to be written then syncs the sink,
+// for at most maxQueueSyncWait.
+func (w *queueWriteSyncer) Sync() error {
+	timer := time.NewTimer(maxQueueSyncWait)
+	defer timer.Stop()
+
+	synced := make(chan error, 1)
+	select {
+	case w.queue <- queuedWrite{synced: synced}:
+	case <-timer.C:
+		return fmt.Errorf("log sink sync timed out after %s: queue full", maxQueueSyncWait)
+	}
+	select {
+	case err := <-synced:
+		return errors.Join(w.takeErr(), err)
+	case <-timer.C:
+		return fmt.Errorf("log sink sync timed out after %s", maxQueueSyncWait)
+	}
+}
+
+// close stops the goroutine, dropping the entries still queued.
+func (w *queueWriteSyncer) close() {
+	close(w.stop)
+}
+
+func (w *queueWriteSyncer) takeErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.err
+	w.err = nil
+
+	return err
+}
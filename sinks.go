@@ -0,0 +1,226 @@
+package log
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// tcpDialTimeout bounds the connection established by a write to the address
+// of a tcp output path, short since the entry is held up meanwhile.
+const tcpDialTimeout = 500 * time.Millisecond
+
+// registerTCPSink registers the tcp scheme with zap the first time a tcp output
+// is opened, returning the error it failed with every time.
+var registerTCPSink = sync.OnceValue(func() error {
+	return zap.RegisterSink("tcp", newTCPSink)
+})
+
+// registerNetworkSinks registers the tcp scheme if any of the outputs of the
+// options is a network connection.
+func (o *Options) registerNetworkSinks() error {
+	paths := append([]string{o.FallbackOutputPath, o.AuditOutputPath}, o.OutputPaths...)
+	paths = append(paths, o.ErrorOutputPaths...)
+	for _, sink := range o.Sinks {
+		paths = append(paths, sink.Path)
+	}
+	for _, path := range paths {
+		if !isNetworkSink(path) {
+			continue
+		}
+		if err := registerTCPSink(); err != nil {
+			return fmt.Errorf("failed to register the tcp sink: %w", err)
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+// SinkOptions is an output with its own minimum level, entries below it are
+// not written to the output.
+type SinkOptions struct {
+	Path  string `json:"path"  mapstructure:"path"`
+	Level string `json:"level" mapstructure:"level"`
+}
+
+// SinksBuilder assembles options writing to several outputs, each with its
+// own level.
+type SinksBuilder struct {
+	sinks []SinkOptions
+}
+
+// Sinks starts building options from a list of outputs, for instance:
+//
+//	opts := log.Sinks().
+//		Console(log.InfoLevel).
+//		File("/var/log/app.log", log.DebugLevel).
+//		Network("collector:5170", log.ErrorLevel).
+//		Options()
+func Sinks() *SinksBuilder {
+	return &SinksBuilder{}
+}
+
+// Console adds standard output as an output for the entries at level or above.
+func (b *SinksBuilder) Console(level Level) *SinksBuilder {
+	return b.add("stdout", level)
+}
+
+// File adds the file at path as an output for the entries at level or above.
+func (b *SinksBuilder) File(path string, level Level) *SinksBuilder {
+	return b.add(path, level)
+}
+
+// Network adds a tcp connection to addr as an output for the entries at level
+// or above.
+func (b *SinksBuilder) Network(addr string, level Level) *SinksBuilder {
+	return b.add("tcp://"+addr, level)
+}
+
+func (b *SinksBuilder) add(path string, level Level) *SinksBuilder {
+	b.sinks = append(b.sinks, SinkOptions{Path: path, Level: level.String()})
+
+	return b
+}
+
+// Options returns the default options with the outputs of the builder in
+// place of the output paths. The level is set to the lowest level of the
+// outputs, so that none of them misses entries.
+func (b *SinksBuilder) Options() *Options {
+	opts := NewOptions()
+	opts.OutputPaths = nil
+	opts.Sinks = append([]SinkOptions(nil), b.sinks...)
+
+	if len(b.sinks) > 0 {
+		lowest := zapcore.FatalLevel
+		for _, sink := range b.sinks {
+			if lvl := sink.zapLevel(opts); lvl < lowest {
+				lowest = lvl
+			}
+		}
+		opts.Level = lowest.String()
+	}
+
+	return opts
+}
+
+// zapLevel returns the level of the sink parsed with the options, falling
+// back to debug.
+func (s SinkOptions) zapLevel(o *Options) zapcore.Level {
+	if s.Level == "" {
+		return zapcore.DebugLevel
+	}
+	lvl, err := o.ParseLevel(s.Level)
+	if err != nil {
+		return zapcore.DebugLevel
+	}
+
+	return lvl
+}
+
+// validate reports the errors in the sink options.
+func (s SinkOptions) validate(o *Options) []error {
+	var errs []error
+	if s.Path == "" {
+		errs = append(errs, fmt.Errorf("not a valid sink: empty path"))
+	} else if _, err := ParseOutputPath(s.Path); err != nil {
+		errs = append(errs, fmt.Errorf("not a valid sink: %w", err))
+	}
+	if s.Level != "" {
+		if _, err := o.ParseLevel(s.Level); err != nil {
+			errs = append(errs, fmt.Errorf("not a valid level for sink %s: %q", s.Path, s.Level))
+		}
+	}
+
+	return errs
+}
+
+// tcpSink is a zap.Sink writing to a tcp connection. The connection is
+// established by the first write, so that a collector down at startup does not
+// keep the logger from being built, and again by the write following a failed
+// one. Connecting does not hold up the other writes: they are dropped until it
+// is done.
+type tcpSink struct {
+	addr string
+
+	mu   sync.Mutex
+	conn net.Conn
+	// dialing is set while a write connects with mu released.
+	dialing bool
+	closed  bool
+}
+
+func newTCPSink(u *url.URL) (zap.Sink, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("tcp output %q has no address", u.String())
+	}
+
+	return &tcpSink{addr: u.Host}, nil
+}
+
+func (s *tcpSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		if err := s.dial(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := s.conn.Write(p)
+	if err != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+
+	return n, err
+}
+
+// dial connects to addr with mu released, so that the other writes and Close
+// do not wait for it. It must be called with mu held.
+func (s *tcpSink) dial() error {
+	if s.closed {
+		return net.ErrClosed
+	}
+	if s.dialing {
+		return fmt.Errorf("log sink %s: connection in progress, entry %w", s.addr, errSinkDropped)
+	}
+	s.dialing = true
+	s.mu.Unlock()
+	conn, err := net.DialTimeout("tcp", s.addr, tcpDialTimeout)
+	s.mu.Lock()
+	s.dialing = false
+	if err != nil {
+		return err
+	}
+	if s.closed {
+		_ = conn.Close()
+
+		return net.ErrClosed
+	}
+	s.conn = conn
+
+	return nil
+}
+
+func (s *tcpSink) Sync() error { return nil }
+
+func (s *tcpSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+
+	return err
+}
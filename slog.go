@@ -0,0 +1,149 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// slogLevelKey is the field used to keep the original numeric level of slog
+// records whose level is not one of the four levels defined by slog.
+const slogLevelKey = "slog_level"
+
+// SlogHandler returns a slog.Handler which writes to the std logger.
+func SlogHandler() slog.Handler { return std().SlogHandler() }
+
+// SlogHandler returns a slog.Handler which writes records through the logger,
+// so that libraries using log/slog share its output, level and fields.
+func (l *zapLogger) SlogHandler() slog.Handler {
+	return &slogHandler{
+		logger:    l.zapLogger,
+		addCaller: !l.opts.DisableCaller,
+	}
+}
+
+// slogHandler is a slog.Handler backed by a zap logger.
+type slogHandler struct {
+	logger    *zap.Logger
+	addCaller bool
+}
+
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.logger.Core().Enabled(slogToZapLevel(level))
+}
+
+func (h *slogHandler) Handle(_ context.Context, r slog.Record) error {
+	ent := zapcore.Entry{
+		LoggerName: h.logger.Name(),
+		Time:       r.Time,
+		Level:      slogToZapLevel(r.Level),
+		Message:    r.Message,
+	}
+	if h.addCaller && r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		ent.Caller = zapcore.NewEntryCaller(frame.PC, frame.File, frame.Line, frame.PC != 0)
+	}
+
+	ce := h.logger.Core().Check(ent, nil)
+	if ce == nil {
+		return nil
+	}
+
+	fields := make([]zapcore.Field, 0, r.NumAttrs()+1)
+	if !isSlogStandardLevel(r.Level) {
+		fields = append(fields, zap.Int(slogLevelKey, int(r.Level)))
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		fields = append(fields, slogAttrToField(attr))
+
+		return true
+	})
+	ce.Write(fields...)
+
+	return nil
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := make([]zapcore.Field, 0, len(attrs))
+	for _, attr := range attrs {
+		fields = append(fields, slogAttrToField(attr))
+	}
+
+	clone := *h
+	clone.logger = h.logger.With(fields...)
+
+	return &clone
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	clone := *h
+	clone.logger = h.logger.With(zap.Namespace(name))
+
+	return &clone
+}
+
+// slogToZapLevel maps a slog level to the nearest level of this package.
+// Custom slog levels sitting exactly between two levels map to the lower one,
+// levels above error map to error so a slog record never panics or exits.
+func slogToZapLevel(level slog.Level) zapcore.Level {
+	levels := []struct {
+		slog slog.Level
+		zap  zapcore.Level
+	}{
+		{slog.LevelDebug, zapcore.DebugLevel},
+		{slog.LevelInfo, zapcore.InfoLevel},
+		{slog.LevelWarn, zapcore.WarnLevel},
+		{slog.LevelError, zapcore.ErrorLevel},
+	}
+
+	nearest := levels[0]
+	for _, l := range levels[1:] {
+		if distance(level, l.slog) < distance(level, nearest.slog) {
+			nearest = l
+		}
+	}
+
+	return nearest.zap
+}
+
+func distance(a, b slog.Level) int {
+	if a > b {
+		return int(a - b)
+	}
+
+	return int(b - a)
+}
+
+func isSlogStandardLevel(level slog.Level) bool {
+	switch level {
+	case slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError:
+		return true
+	default:
+		return false
+	}
+}
+
+// slogAttrToField converts a slog attribute into a zap field.
+func slogAttrToField(attr slog.Attr) zapcore.Field {
+	value := attr.Value.Resolve()
+	if value.Kind() != slog.KindGroup {
+		return zap.Any(attr.Key, value.Any())
+	}
+
+	attrs := value.Group()
+
+	return zap.Object(attr.Key, zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		for _, a := range attrs {
+			slogAttrToField(a).AddTo(enc)
+		}
+
+		return nil
+	}))
+}
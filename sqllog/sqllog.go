@@ -0,0 +1,108 @@
+// Package sqllog logs SQL queries in a uniform structured format, so that all
+// database logging can be queried the same way.
+package sqllog
+
+import (
+	"time"
+
+	"github.com/lwm-galactic/log"
+)
+
+const (
+	message = "sql query"
+
+	redacted       = "[REDACTED]"
+	truncatedMark  = "...(truncated)"
+	durationKey    = "duration_ms"
+	queryKey       = "query"
+	argsKey        = "args"
+	queryLengthKey = "query_length"
+)
+
+// Options sqllog 配置项.
+type Options struct {
+	SlowThreshold  time.Duration `json:"slow-threshold"   mapstructure:"slow-threshold"`   // 超过该耗时的查询以 warn 级别输出，其余为 debug
+	MaxQueryLength int           `json:"max-query-length" mapstructure:"max-query-length"` // 查询语句的最大长度，超出部分被截断，0 表示不截断
+	MaxArgLength   int           `json:"max-arg-length"   mapstructure:"max-arg-length"`   // 字符串参数的最大长度，超出部分被截断，0 表示不截断
+	RedactArgs     bool          `json:"redact-args"      mapstructure:"redact-args"`      // 是否隐藏查询参数的值
+}
+
+// NewOptions 创建一个默认的配置项.
+func NewOptions() *Options {
+	return &Options{
+		SlowThreshold:  200 * time.Millisecond,
+		MaxQueryLength: 2048,
+		MaxArgLength:   256,
+		RedactArgs:     false,
+	}
+}
+
+// Logger logs SQL queries through a log.Logger.
+type Logger struct {
+	logger log.Logger
+	opts   *Options
+}
+
+// New creates a SQL logger writing to l.
+func New(l log.Logger, opts *Options) *Logger {
+	if opts == nil {
+		opts = NewOptions()
+	}
+
+	return &Logger{logger: l, opts: opts}
+}
+
+// Log logs a query with its arguments, duration and error. Queries failing or
+// slower than the threshold are logged at warn level, the others at debug.
+func (l *Logger) Log(query string, args []interface{}, dur time.Duration, err error) {
+	fields := []log.Field{
+		log.String(queryKey, truncate(query, l.opts.MaxQueryLength)),
+		log.Any(argsKey, l.args(args)),
+		log.Float64(durationKey, float64(dur)/float64(time.Millisecond)),
+	}
+	if l.opts.MaxQueryLength > 0 && len(query) > l.opts.MaxQueryLength {
+		fields = append(fields, log.Int(queryLengthKey, len(query)))
+	}
+	if err != nil {
+		fields = append(fields, log.Err(err))
+	}
+
+	if err != nil || (l.opts.SlowThreshold > 0 && dur >= l.opts.SlowThreshold) {
+		l.logger.Warn(message, fields...)
+
+		return
+	}
+	l.logger.Debug(message, fields...)
+}
+
+func (l *Logger) args(args []interface{}) []interface{} {
+	out := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case nil:
+			out[i] = nil
+		case string:
+			if l.opts.RedactArgs {
+				out[i] = redacted
+			} else {
+				out[i] = truncate(v, l.opts.MaxArgLength)
+			}
+		default:
+			if l.opts.RedactArgs {
+				out[i] = redacted
+			} else {
+				out[i] = v
+			}
+		}
+	}
+
+	return out
+}
+
+func truncate(s string, n int) string {
+	if n <= 0 || len(s) <= n {
+		return s
+	}
+
+	return s[:n] + truncatedMark
+}
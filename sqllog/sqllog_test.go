@@ -0,0 +1,82 @@
+package sqllog_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lwm-galactic/log"
+	"github.com/lwm-galactic/log/sqllog"
+	"github.com/stretchr/testify/assert"
+)
+
+func newLogger(t *testing.T) (log.Logger, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "test.log")
+	opts := log.NewOptions()
+	opts.Level = "debug"
+	opts.Format = "json"
+	opts.OutputPaths = []string{path}
+
+	return log.New(opts), path
+}
+
+func readEntries(t *testing.T, path string) []map[string]interface{} {
+	t.Helper()
+
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	var entries []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := map[string]interface{}{}
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func Test_Log(t *testing.T) {
+	logger, path := newLogger(t)
+	opts := sqllog.NewOptions()
+	opts.MaxQueryLength = 32
+	l := sqllog.New(logger, opts)
+
+	l.Log("SELECT * FROM users WHERE id = ?", []interface{}{42}, 3*time.Millisecond, nil)
+	l.Log("SELECT * FROM orders WHERE user_id = ? AND status = ?", []interface{}{42, "paid"}, time.Second, nil)
+	l.Log("DELETE FROM users", nil, time.Millisecond, errors.New("permission denied"))
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, "DEBUG", entries[0]["level"])
+	assert.Equal(t, "SELECT * FROM users WHERE id = ?", entries[0]["query"])
+	assert.Equal(t, []interface{}{float64(42)}, entries[0]["args"])
+	assert.Equal(t, float64(3), entries[0]["duration_ms"])
+
+	assert.Equal(t, "WARN", entries[1]["level"])
+	assert.True(t, strings.HasSuffix(entries[1]["query"].(string), "...(truncated)"))
+	assert.Equal(t, float64(len("SELECT * FROM orders WHERE user_id = ? AND status = ?")), entries[1]["query_length"])
+
+	assert.Equal(t, "WARN", entries[2]["level"])
+	assert.Equal(t, "permission denied", entries[2]["error"])
+}
+
+func Test_RedactArgs(t *testing.T) {
+	logger, path := newLogger(t)
+	opts := sqllog.NewOptions()
+	opts.RedactArgs = true
+	sqllog.New(logger, opts).Log("UPDATE users SET password = ?", []interface{}{"secret"}, time.Millisecond, nil)
+
+	entries := readEntries(t, path)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, []interface{}{"[REDACTED]"}, entries[0]["args"])
+}
@@ -0,0 +1,71 @@
+package log
+
+import (
+	"os"
+	"runtime/debug"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+const startupMessage = "startup"
+
+// Startup logs the startup entry of the service with the std logger.
+func Startup(info map[string]interface{}) {
+	std().zapLogger.Info(startupMessage, std().startupFields(info)...)
+}
+
+// Startup logs a "startup" entry at info level, giving every service the same
+// queryable record of how it was started. Along with the info fields, such as
+// the version or a summary of the configuration, it holds the Go runtime and
+// platform as with RuntimeFields, the host and pid, and the main module and
+// VCS revision found in the build info if the binary was built with it.
+func (l *zapLogger) Startup(info map[string]interface{}) {
+	l.zapLogger.Info(startupMessage, l.startupFields(info)...)
+}
+
+func (l *zapLogger) startupFields(info map[string]interface{}) []zap.Field {
+	keys := make([]string, 0, len(info))
+	for k := range info {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fields := make([]zap.Field, 0, len(keys)+10)
+	for _, k := range keys {
+		fields = append(fields, zap.Any(k, info[k]))
+	}
+	// The runtime fields are already on every entry with RuntimeFields.
+	if !l.opts.RuntimeFields {
+		fields = append(fields, runtimeFields()...)
+	}
+	if host, err := os.Hostname(); err == nil {
+		fields = append(fields, zap.String("host", host))
+	}
+	fields = append(fields, zap.Int("pid", os.Getpid()))
+
+	return append(fields, buildInfoFields()...)
+}
+
+// buildInfoFields returns the main module of the binary and the VCS settings
+// recorded when it was built, if any.
+func buildInfoFields() []zap.Field {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil
+	}
+
+	fields := []zap.Field{zap.String("module", bi.Main.Path), zap.String("module_version", bi.Main.Version)}
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			fields = append(fields, zap.String("commit", setting.Value))
+		case "vcs.time":
+			fields = append(fields, zap.String("commit_time", setting.Value))
+		case "vcs.modified":
+			fields = append(fields, zap.Bool("dirty", setting.Value == "true"))
+		}
+	}
+
+	return fields
+}
@@ -0,0 +1,142 @@
+package log
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+const numLevels = int(zapcore.FatalLevel-zapcore.DebugLevel) + 1
+
+// LogStats reports where the entries of a logger went, counted per level name
+// since the logger was built. It is shared by all the loggers derived from
+// the same logger and is meant to be exposed by a debug endpoint.
+type LogStats struct {
+	// Written is the number of entries handed to the output sinks.
+	Written map[string]uint64 `json:"written"`
+	// SampledOut is the number of entries dropped by the sampler.
+	SampledOut map[string]uint64 `json:"sampled-out"`
+	// Deduped is the number of entries dropped as duplicates, for instance by
+	// the error cooldown.
+	Deduped map[string]uint64 `json:"deduped"`
+	// Bytes is the size of the encoded entries, summed over all the outputs.
+	// It is only counted with LogSizeAccounting.
+	Bytes map[string]uint64 `json:"bytes"`
+	// DroppedOnFull is the number of entries handed to the output sinks but
+	// dropped by one of them, because its queue was full or a previous write
+	// was still blocked. With BufferSize, the sinks only see the buffered
+	// entries once flushed, and the drops are counted at the level of the
+	// entry which triggered the flush.
+	DroppedOnFull map[string]uint64 `json:"dropped-on-full"`
+}
+
+// levelCounters is a set of counters indexed by level.
+type levelCounters [numLevels]atomic.Uint64
+
+func (c *levelCounters) inc(lvl zapcore.Level) {
+	c.add(lvl, 1)
+}
+
+func (c *levelCounters) add(lvl zapcore.Level, n uint64) {
+	if i := int(lvl - zapcore.DebugLevel); i >= 0 && i < numLevels {
+		c[i].Add(n)
+	}
+}
+
+func (c *levelCounters) snapshot() map[string]uint64 {
+	m := make(map[string]uint64, numLevels)
+	for i := range c {
+		m[(zapcore.DebugLevel + zapcore.Level(i)).String()] = c[i].Load()
+	}
+
+	return m
+}
+
+// stats holds the counters behind LogStats.
+type stats struct {
+	written    levelCounters
+	sampledOut levelCounters
+	deduped    levelCounters
+	bytes      levelCounters
+	dropped    levelCounters
+}
+
+func (s *stats) snapshot() LogStats {
+	return LogStats{
+		Written:       s.written.snapshot(),
+		SampledOut:    s.sampledOut.snapshot(),
+		Deduped:       s.deduped.snapshot(),
+		Bytes:         s.bytes.snapshot(),
+		DroppedOnFull: s.dropped.snapshot(),
+	}
+}
+
+// samplerHook counts the entries dropped by the sampler.
+func (s *stats) samplerHook(ent zapcore.Entry, dec zapcore.SamplingDecision) {
+	if dec&zapcore.LogDropped != 0 {
+		s.sampledOut.inc(ent.Level)
+	}
+}
+
+// statsCore is a zapcore.Core counting the entries written to the wrapped
+// core, and those the sinks dropped.
+type statsCore struct {
+	zapcore.Core
+	stats *stats
+}
+
+func (c *statsCore) With(fields []zapcore.Field) zapcore.Core {
+	return &statsCore{Core: c.Core.With(fields), stats: c.stats}
+}
+
+func (c *statsCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *statsCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	c.stats.written.inc(ent.Level)
+	err := c.Core.Write(ent, fields)
+	if errors.Is(err, errSinkDropped) {
+		c.stats.dropped.inc(ent.Level)
+	}
+
+	return err
+}
+
+// sizeEncoder is a zapcore.Encoder counting the bytes of the entries encoded
+// by the wrapped encoder.
+type sizeEncoder struct {
+	zapcore.Encoder
+	stats *stats
+}
+
+func (enc *sizeEncoder) Clone() zapcore.Encoder {
+	return &sizeEncoder{Encoder: enc.Encoder.Clone(), stats: enc.stats}
+}
+
+func (enc *sizeEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	buf, err := enc.Encoder.EncodeEntry(ent, fields)
+	if err == nil {
+		enc.stats.bytes.add(ent.Level, uint64(buf.Len()))
+	}
+
+	return buf, err
+}
+
+// Stats returns the statistics of the std logger.
+func Stats() LogStats { return std().Stats() }
+
+// Stats returns how many entries were written or dropped, per level.
+func (l *zapLogger) Stats() LogStats {
+	if l.state == nil {
+		return (&stats{}).snapshot()
+	}
+
+	return l.state.stats.snapshot()
+}
@@ -0,0 +1,62 @@
+package log
+
+import (
+	"errors"
+	"regexp"
+
+	"go.uber.org/multierr"
+)
+
+// DefaultIgnoreSyncErrors are the default IgnoreSyncErrors: the errors
+// returned on Linux when syncing stdout or stderr while they are a pipe or a
+// terminal, which are harmless.
+var DefaultIgnoreSyncErrors = []string{
+	`sync /dev/std(out|err): (invalid argument|inappropriate ioctl for device)`,
+}
+
+// Sync flushes the std logger, returning the errors which are not ignored.
+func Sync() error { return std().Sync() }
+
+// Sync flushes any buffered entries like Flush, but returns the errors the
+// outputs failed to sync with, leaving out the ones matching IgnoreSyncErrors.
+func (l *zapLogger) Sync() error {
+	err := l.zapLogger.Sync()
+	if err == nil {
+		return nil
+	}
+	// Invalid patterns are reported by Validate.
+	ignored, _ := compilePatterns(l.opts.IgnoreSyncErrors)
+
+	var errs []error
+	for _, err := range multierr.Errors(err) {
+		if !matchesAny(ignored, err.Error()) {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// compilePatterns compiles the regular expressions of patterns.
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+
+	return res, nil
+}
+
+func matchesAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+
+	return false
+}
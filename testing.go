@@ -0,0 +1,51 @@
+package log
+
+import (
+	"bytes"
+)
+
+// TestingT is the subset of testing.TB used by SetTestOutput, satisfied by
+// *testing.T. It keeps the package from depending on the testing package.
+type TestingT interface {
+	Logf(format string, args ...interface{})
+}
+
+// SetTestOutput redirects the std logger to t.Logf, so that logs written
+// through the package level functions show up in the test output alongside
+// the test they belong to, instead of polluting stdout. The returned function
+// restores the previous std logger and must be called before the test ends.
+// Unlike Init, it leaves the standard library logger untouched:
+//
+//	func TestHandler(t *testing.T) {
+//		defer log.SetTestOutput(t)()
+//
+//		log.Info("only printed with go test -v or when the test fails")
+//	}
+func SetTestOutput(t TestingT) func() {
+	opts := NewOptions()
+	opts.Level = DebugLevel.String()
+	opts.OutputPaths = nil
+	opts.Writer = testWriter{t: t}
+
+	logger, err := buildLogger(opts)
+	if err != nil {
+		panic(err)
+	}
+	prev := std()
+	stdLogger.Store(logger)
+
+	return func() {
+		stdLogger.Store(prev)
+	}
+}
+
+// testWriter writes every entry as one t.Logf line.
+type testWriter struct {
+	t TestingT
+}
+
+func (w testWriter) Write(p []byte) (int, error) {
+	w.t.Logf("%s", bytes.TrimRight(p, "\n"))
+
+	return len(p), nil
+}
@@ -0,0 +1,128 @@
+package log
+
+import (
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// maxThrottledCallers bounds the callers remembered by a throttled logger, the
+// ones whose interval is over are evicted once it is reached.
+const maxThrottledCallers = 1 << 12
+
+// Throttle returns a child of the std logger writing at most one entry per
+// caller every d.
+func Throttle(d time.Duration) Logger { return std().Throttle(d) }
+
+// Throttle returns a child logger writing at most one entry from each caller
+// every d, the entries logged from the same caller in the meantime being
+// dropped. Unlike the samplers, it does not count entries but remembers when
+// each caller was last written, which suits loops such as health checks
+// logging at every tick. The entries it lets through are still sampled.
+// The loggers derived from the returned one share its throttling.
+func (l *zapLogger) Throttle(d time.Duration) Logger {
+	if d <= 0 {
+		return l
+	}
+	var st *stats
+	if l.state != nil {
+		st = l.state.stats
+	}
+	state := &throttleState{last: map[string]time.Time{}}
+
+	return l.derive(l.zapLogger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &throttleCore{Core: core, interval: d, state: state, stats: st}
+	})))
+}
+
+// throttleCore is a zapcore.Core writing at most one entry per caller every
+// interval.
+type throttleCore struct {
+	zapcore.Core
+	interval time.Duration
+	state    *throttleState
+	stats    *stats
+}
+
+type throttleState struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func (c *throttleCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.Core = c.Core.With(fields)
+
+	return &clone
+}
+
+// Check throttles the entry before the wrapped core checks it, so that the
+// entries it lets through are still sampled and counted like any other.
+func (c *throttleCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Enabled(ent.Level) {
+		return ce
+	}
+	if !c.state.allow(callerKey(ent.Message), ent.Time, c.interval) {
+		if c.stats != nil {
+			c.stats.sampledOut.inc(ent.Level)
+		}
+
+		return ce
+	}
+
+	return c.Core.Check(ent, ce)
+}
+
+// packagePrefix prefixes the names of the functions of this package.
+var packagePrefix = reflect.TypeOf(throttleCore{}).PkgPath() + "."
+
+// callerKey returns the file:line of the code logging the entry being
+// checked, the first frame which is neither in this package nor in zap. The
+// caller of an entry is only set by zap after the entry was checked, so it
+// cannot be read from the entry. It returns fallback when no such frame is
+// found.
+func callerKey(fallback string) string {
+	var pcs [32]uintptr
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs[:])])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, packagePrefix) && !strings.HasPrefix(frame.Function, "go.uber.org/zap") {
+			return frame.File + ":" + strconv.Itoa(frame.Line)
+		}
+		if !more {
+			return fallback
+		}
+	}
+}
+
+// allow reports whether an entry from the caller at t is written, recording
+// it as the last one if so. A caller not seen yet is always written, even when
+// too many callers are remembered to record it.
+func (ts *throttleState) allow(caller string, t time.Time, interval time.Duration) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	last, ok := ts.last[caller]
+	if ok && t.Sub(last) < interval {
+		return false
+	}
+	if !ok && len(ts.last) >= maxThrottledCallers {
+		for key, last := range ts.last {
+			if t.Sub(last) >= interval {
+				delete(ts.last, key)
+			}
+		}
+		if len(ts.last) >= maxThrottledCallers {
+			return true
+		}
+	}
+	ts.last[caller] = t
+
+	return true
+}
@@ -0,0 +1,111 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// truncatedObjectMember ends the JSON objects truncated by MaxFieldLength.
+	truncatedObjectMember = `"...":"truncated"`
+	// truncatedArrayElement ends the JSON arrays truncated by MaxFieldLength.
+	truncatedArrayElement = `"...truncated"`
+)
+
+// truncateLongStrings returns a field rewrite truncating the string values
+// longer than max bytes. Values holding a JSON object or array are cut after
+// the last member or element fitting, followed by a marker, so that they
+// remain valid JSON. Other values are cut at max bytes, on a rune boundary.
+func truncateLongStrings(max int) func([]zapcore.Field) []zapcore.Field {
+	return func(fields []zapcore.Field) []zapcore.Field {
+		var out []zapcore.Field
+		for i, f := range fields {
+			if f.Type != zapcore.StringType || len(f.String) <= max {
+				continue
+			}
+			if out == nil {
+				out = append(make([]zapcore.Field, 0, len(fields)), fields...)
+			}
+			if s, ok := truncateJSON(f.String, max); ok {
+				out[i].String = s
+			} else {
+				out[i].String = truncateBytes(f.String, max)
+			}
+		}
+		if out == nil {
+			return fields
+		}
+
+		return out
+	}
+}
+
+// truncateBytes cuts s to at most max bytes without splitting a rune.
+func truncateBytes(s string, max int) string {
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+
+	return s[:max]
+}
+
+// truncateJSON truncates s if it holds a JSON object or array, keeping the
+// members or elements which fit in max bytes along with the marker. The
+// result can exceed max when not even the marker fits. It returns false when
+// s is not a JSON object or array.
+func truncateJSON(s string, max int) (string, bool) {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" || (trimmed[0] != '{' && trimmed[0] != '[') || !json.Valid([]byte(trimmed)) {
+		return "", false
+	}
+	if len(trimmed) <= max {
+		return trimmed, true
+	}
+
+	dec := json.NewDecoder(strings.NewReader(trimmed))
+	// Consume the opening delimiter, the input is known to be valid.
+	_, _ = dec.Token()
+	object := trimmed[0] == '{'
+	marker, closing := truncatedArrayElement, "]"
+	if object {
+		marker, closing = truncatedObjectMember, "}"
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(trimmed[:1])
+	for dec.More() {
+		var member bytes.Buffer
+		if object {
+			key, _ := dec.Token()
+			k, _ := json.Marshal(key)
+			member.Write(k)
+			member.WriteByte(':')
+		}
+		var value json.RawMessage
+		_ = dec.Decode(&value)
+		member.Write(value)
+
+		need := member.Len() + len(",") + len(marker) + len(closing)
+		if buf.Len() > 1 {
+			need += len(",")
+		}
+		if buf.Len()+need > max {
+			break
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.Write(member.Bytes())
+	}
+	if buf.Len() > 1 {
+		buf.WriteByte(',')
+	}
+	buf.WriteString(marker)
+	buf.WriteString(closing)
+
+	return buf.String(), true
+}
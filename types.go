@@ -1,6 +1,8 @@
 package log
 
 import (
+	"fmt"
+
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -89,3 +91,35 @@ var (
 	Uintptr     = zap.Uintptr
 	Uintptrs    = zap.Uintptrs
 )
+
+// byteSize is a number of bytes rendered in binary units by String.
+type byteSize int64
+
+// byteSizeUnits are the binary units byteSize renders in, by power of 1024.
+var byteSizeUnits = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+
+func (b byteSize) String() string {
+	n := float64(b)
+	sign := ""
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	if n < 1024 {
+		return fmt.Sprintf("%s%d B", sign, int64(n))
+	}
+
+	unit := 0
+	for n >= 1024 && unit < len(byteSizeUnits)-1 {
+		n /= 1024
+		unit++
+	}
+
+	return fmt.Sprintf("%s%.1f %s", sign, n, byteSizeUnits[unit])
+}
+
+// ByteSize constructs a field rendering a number of bytes in binary units,
+// such as "1.0 GiB". With RawByteSizes, the raw number is also logged in a
+// field with the "_bytes" suffix.
+func ByteSize(key string, bytes int64) Field {
+	return zap.Stringer(key, byteSize(bytes))
+}
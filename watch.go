@@ -0,0 +1,189 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// levelFilePollInterval is the interval at which WatchLevelFile and
+// WatchConfig read their file.
+const levelFilePollInterval = time.Second
+
+// WatchLevelFile makes the std logger follow the level written in a file.
+func WatchLevelFile(path string) func() { return std().WatchLevelFile(path) }
+
+// WatchLevelFile reads the level from the file at path, such as "debug" or
+// "warn", and applies it to the logger and all the loggers sharing its core.
+// The file is polled every second, until the returned func is called.
+// Unreadable files and invalid levels are logged and ignored, keeping the
+// previous level. It has no effect on loggers created by NewLogger or with a
+// LevelEnabler.
+func (l *zapLogger) WatchLevelFile(path string) func() {
+	if l.state == nil {
+		return func() {}
+	}
+
+	w := &levelFileWatcher{
+		path:   path,
+		level:  l.state.level,
+		parse:  l.opts.ParseLevel,
+		logger: l.zapLogger.WithOptions(zap.WithCaller(false)),
+	}
+	w.poll()
+
+	return pollEvery(l.opts.clock(), levelFilePollInterval, w.poll)
+}
+
+// pollEvery calls poll at every tick of a clock ticker with the given
+// interval, until the returned func is called.
+func pollEvery(clock zapcore.Clock, interval time.Duration, poll func()) func() {
+	ticker := clock.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				poll()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// levelFileWatcher applies the level read from a file.
+type levelFileWatcher struct {
+	path   string
+	level  zap.AtomicLevel
+	parse  func(string) (Level, error)
+	logger *zap.Logger
+
+	// last is the content of the file at the previous poll, the file is only
+	// parsed when it changes.
+	last    []byte
+	lastErr string
+}
+
+func (w *levelFileWatcher) poll() {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		// Only report an error once while it persists.
+		if err.Error() != w.lastErr {
+			w.lastErr = err.Error()
+			w.logger.Error("failed to read log level file", zap.String("path", w.path), zap.Error(err))
+		}
+
+		return
+	}
+	w.lastErr = ""
+
+	data = bytes.TrimSpace(data)
+	// An empty file is most likely being rewritten, zap would parse it as info.
+	if len(data) == 0 || (w.last != nil && bytes.Equal(data, w.last)) {
+		return
+	}
+	w.last = data
+
+	lvl, err := w.parse(string(data))
+	if err != nil {
+		w.logger.Error("invalid log level in file, keeping the current level",
+			zap.String("path", w.path), zap.String("level", w.level.Level().String()), zap.Error(err))
+
+		return
+	}
+	if lvl != w.level.Level() {
+		w.logger.Info("log level changed", zap.String("path", w.path),
+			zap.String("from", w.level.Level().String()), zap.String("to", lvl.String()))
+		w.level.SetLevel(lvl)
+	}
+}
+
+// WatchConfig makes the std logger follow the level of a config file.
+func WatchConfig(path string) (func(), error) { return std().WatchConfig(path) }
+
+// WatchConfig reads the json options from the file at path and applies them
+// to the logger and all the loggers sharing its core, then polls the file every
+// second until the returned func is called. Only the level can be changed
+// without building a new logger, the other options are validated but not
+// applied. A config failing to decode or validate is logged and ignored,
+// keeping the previous level; it is returned as an error when loaded first.
+// It has no effect on loggers created by NewLogger or with a LevelEnabler.
+func (l *zapLogger) WatchConfig(path string) (func(), error) {
+	if l.state == nil {
+		return func() {}, nil
+	}
+
+	w := &configWatcher{
+		path:   path,
+		level:  l.state.level,
+		logger: l.zapLogger.WithOptions(zap.WithCaller(false)),
+	}
+	if err := w.load(); err != nil {
+		return nil, err
+	}
+
+	return pollEvery(l.opts.clock(), levelFilePollInterval, w.poll), nil
+}
+
+// configWatcher applies the level of the options read from a file.
+type configWatcher struct {
+	path   string
+	level  zap.AtomicLevel
+	logger *zap.Logger
+
+	// last is the content of the file at the previous load.
+	last    []byte
+	lastErr string
+}
+
+func (w *configWatcher) poll() {
+	if err := w.load(); err != nil {
+		// Only report an error once while it persists.
+		if err.Error() != w.lastErr {
+			w.lastErr = err.Error()
+			w.logger.Error("failed to reload log config, keeping the current one",
+				zap.String("path", w.path), zap.Error(err))
+		}
+
+		return
+	}
+	w.lastErr = ""
+}
+
+// load reads and applies the config file if it changed since the last load.
+func (w *configWatcher) load() error {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) == 0 || bytes.Equal(data, w.last) {
+		return nil
+	}
+
+	opts := NewOptions()
+	if err := json.Unmarshal(data, opts); err != nil {
+		return fmt.Errorf("decoding %s: %w", w.path, err)
+	}
+	if errs := opts.Validate(); len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	w.last = data
+
+	if lvl := opts.zapLevel(); lvl != w.level.Level() {
+		w.logger.Info("log level changed", zap.String("path", w.path),
+			zap.String("from", w.level.Level().String()), zap.String("to", lvl.String()))
+		w.level.SetLevel(lvl)
+	}
+
+	return nil
+}